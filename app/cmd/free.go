@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/banditmoscow1337/spos/app"
+	"github.com/banditmoscow1337/spos/kernel/mm"
+)
+
+// readMeminfo returns the values of /proc/meminfo in kB
+func readMeminfo(ctx *app.Context) (map[string]int64, error) {
+	f, err := ctx.Open("/proc/meminfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info := make(map[string]int64)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		v, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		info[strings.TrimSuffix(fields[0], ":")] = v
+	}
+	return info, s.Err()
+}
+
+func freemain(ctx *app.Context) error {
+	mega := ctx.Flag().Bool("m", false, "show the sizes in MiB")
+	err := ctx.ParseFlags()
+	if err != nil {
+		return err
+	}
+
+	info, err := readMeminfo(ctx)
+	if err != nil {
+		return err
+	}
+	unit := int64(1)
+	if *mega {
+		unit = 1024
+	}
+	total, free := info["MemTotal"], info["MemFree"]
+	cache := info["Buffers"] + info["Cached"]
+
+	tw := tabwriter.NewWriter(ctx.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "\ttotal\tused\tfree\tbuff/cache\tavailable\t\n")
+	fmt.Fprintf(tw, "Mem:\t%d\t%d\t%d\t%d\t%d\t\n",
+		total/unit, (total-free-cache)/unit, free/unit, cache/unit, info["MemAvailable"]/unit)
+	fmt.Fprintf(tw, "Slab:\t%d\t\t\t\t\t\n", info["Slab"]/unit)
+	fmt.Fprintf(tw, "PageTables:\t%d\t\t\t\t\t\n", info["PageTables"]/unit)
+	// linux has no meminfo key for the firmware regions
+	var reserved int64
+	for _, r := range mm.ReservedRegions() {
+		reserved += int64(r.Len / 1024)
+	}
+	fmt.Fprintf(tw, "Reserved:\t%d\t\t\t\t\t\n", reserved/unit)
+	return tw.Flush()
+}
+
+func init() {
+	app.Register("free", freemain)
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/banditmoscow1337/spos/app"
+	"github.com/banditmoscow1337/spos/kernel"
+)
+
+func fmtLatency(ns uint64) string {
+	return time.Duration(ns).String()
+}
+
+func printLatency(tw io.Writer, name string, s *kernel.CallStat) {
+	fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t\n", name, s.Count,
+		fmtLatency(s.Total), fmtLatency(s.Avg()),
+		fmtLatency(s.Percentile(0.5)), fmtLatency(s.Percentile(0.99)), fmtLatency(s.Max))
+}
+
+func printIRQStats(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "irq\tvector\tcount\tcpus\t\n")
+	for _, s := range kernel.IRQStats() {
+		var total uint64
+		counts := kernel.TrapCounts(s.Vector)
+		for _, c := range counts {
+			total += c
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%v\t\n", s.Line, s.Vector, total, counts)
+	}
+	fmt.Fprintf(tw, "\t\t\t\t\n")
+	fmt.Fprintf(tw, "handler\tcalls\ttotal\tavg\tp50\tp99\tmax\t\n")
+	for _, s := range kernel.IRQStats() {
+		printLatency(tw, fmt.Sprintf("IRQ-%d", s.Line), &s.CallStat)
+	}
+	return tw.Flush()
+}
+
+func printSyscallStats(w io.Writer, top int) error {
+	stats := kernel.SyscallStats()
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Total > stats[j].Total
+	})
+	if top > 0 && len(stats) > top {
+		stats = stats[:top]
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "syscall\tcalls\ttotal\tavg\tp50\tp99\tmax\t\n")
+	for i := range stats {
+		printLatency(tw, kernel.SyscallName(stats[i].No), &stats[i].CallStat)
+	}
+	return tw.Flush()
+}
+
+func irqstatmain(ctx *app.Context) error {
+	sys := ctx.Flag().Bool("s", false, "show the syscalls sorted by the total time too")
+	top := ctx.Flag().Int("n", 20, "the number of syscalls to show, 0 for all")
+	err := ctx.ParseFlags()
+	if err != nil {
+		return err
+	}
+	err = printIRQStats(ctx.Stdout)
+	if err != nil || !*sys {
+		return err
+	}
+	fmt.Fprintln(ctx.Stdout)
+	return printSyscallStats(ctx.Stdout, *top)
+}
+
+func init() {
+	app.Register("irqstat", irqstatmain)
+}
@@ -0,0 +1,20 @@
+package cmd
+
+import (
+	"syscall"
+
+	"github.com/banditmoscow1337/spos/app"
+)
+
+func poweroffmain(ctx *app.Context) error {
+	return syscall.Reboot(syscall.LINUX_REBOOT_CMD_POWER_OFF)
+}
+
+func rebootmain(ctx *app.Context) error {
+	return syscall.Reboot(syscall.LINUX_REBOOT_CMD_RESTART)
+}
+
+func init() {
+	app.Register("poweroff", poweroffmain)
+	app.Register("reboot", rebootmain)
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/banditmoscow1337/spos/app"
+	"github.com/banditmoscow1337/spos/drivers/kbd"
+	"github.com/banditmoscow1337/spos/kernel"
+)
+
+// the calls made by the idle runtime all the time, skipped by default
+const straceNoisy = "futex,sched_yield,nanosleep,epoll_pwait,clock_gettime"
+
+func parseSyscalls(list string) ([]uintptr, error) {
+	var nos []uintptr
+	for _, name := range strings.Split(list, ",") {
+		if name == "" {
+			continue
+		}
+		no, ok := kernel.SyscallNo(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown syscall %s", name)
+		}
+		nos = append(nos, no)
+	}
+	return nos, nil
+}
+
+func printTraceEvent(w io.Writer, ev *kernel.TraceEvent) {
+	ret := fmt.Sprintf("%d", int64(ev.Ret))
+	if int64(ev.Ret) < 0 && int64(ev.Ret) > -4096 {
+		errno := syscall.Errno(-int64(ev.Ret))
+		ret = fmt.Sprintf("-1 (%s)", errno)
+	}
+	var fwd string
+	if ev.Forwarded {
+		fwd = "*"
+	}
+	fmt.Fprintf(w, "%4d%1s %s(%#x, %#x, %#x, %#x, %#x, %#x) = %s <%.6f>\n",
+		ev.Tid, fwd, kernel.SyscallName(ev.No),
+		ev.Args[0], ev.Args[1], ev.Args[2], ev.Args[3], ev.Args[4], ev.Args[5],
+		ret, time.Duration(ev.Dur).Seconds())
+}
+
+func stracemain(ctx *app.Context) error {
+	include := ctx.Flag().String("e", "", "comma separated syscalls to trace, all by default")
+	exclude := ctx.Flag().String("x", straceNoisy, "comma separated syscalls not to trace")
+	tid := ctx.Flag().Int("p", 0, "only show the calls of the thread")
+	count := ctx.Flag().Int("n", 0, "stop after the number of calls")
+	dur := ctx.Flag().Duration("t", 0, "stop after the duration, or when q is pressed")
+	err := ctx.ParseFlags()
+	if err != nil {
+		return err
+	}
+	nos, err := parseSyscalls(*include)
+	if err != nil {
+		return err
+	}
+	skip, err := parseSyscalls(*exclude)
+	if err != nil {
+		return err
+	}
+	// the calls asked for explicitly are traced even if they're noisy
+	if len(nos) > 0 && *exclude == straceNoisy {
+		skip = nil
+	}
+
+	// the writes of the tracer itself are not recorded
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	kernel.StartTrace(kernel.TraceFilter{
+		Nos:        nos,
+		Skip:       skip,
+		SkipThread: true,
+		SkipTid:    syscall.Gettid(),
+	})
+	defer kernel.StopTrace()
+
+	var deadline time.Time
+	if *dur > 0 {
+		deadline = time.Now().Add(*dur)
+	}
+	var evs [64]kernel.TraceEvent
+	next := kernel.TraceHead()
+	shown := 0
+	for !kbd.Pressed('q') {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return nil
+		}
+		var n int
+		n, next = kernel.ReadTrace(next, evs[:])
+		for i := 0; i < n; i++ {
+			if *tid != 0 && evs[i].Tid != *tid {
+				continue
+			}
+			printTraceEvent(ctx.Stdout, &evs[i])
+			shown++
+			if *count > 0 && shown >= *count {
+				return nil
+			}
+		}
+		if n == 0 {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	return nil
+}
+
+func init() {
+	app.Register("strace", stracemain)
+}
@@ -11,18 +11,32 @@ import (
 )
 
 func printstat(ctx *app.Context) {
-	var stat1, stat2 [20]int64
-	kernel.ThreadStat(&stat1)
+	stat1 := kernel.ThreadStat(nil)
 	time.Sleep(time.Second)
-	kernel.ThreadStat(&stat2)
+	stat2 := kernel.ThreadStat(nil)
+
+	// threads created during the sleep are ignored,
+	// and the ones exited or reused are reported as idle
+	n := len(stat1)
+	if len(stat2) < n {
+		n = len(stat2)
+	}
+	for i := 0; i < n; i++ {
+		if stat1[i] <= 0 || stat2[i] < stat1[i] {
+			stat1[i], stat2[i] = 0, 0
+		}
+	}
 
 	var sum int64
-	for i := range stat1 {
+	for i := 0; i < n; i++ {
 		sum += stat2[i] - stat1[i]
 	}
+	if sum == 0 {
+		return
+	}
 	var tids []string
 	var percents []string
-	for i := range stat1 {
+	for i := 0; i < n; i++ {
 		if stat1[i] == 0 {
 			continue
 		}
@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/banditmoscow1337/spos/app"
+	"github.com/banditmoscow1337/spos/bootcfg"
 	"github.com/banditmoscow1337/spos/console"
 
 	"github.com/mattn/go-shellwords"
@@ -67,17 +68,22 @@ func runApp(ctx *app.Context, name string, args []string, bg bool) error {
 	return app.Run(name, &nctx)
 }
 
+// Bootstrap runs the init app of spos_INIT on the console, the shell by default
 func Bootstrap() {
 	con := console.Console()
 	log.SetOutput(con)
+	cfg := bootcfg.Get()
 	ctx := &app.Context{
-		Args:   []string{"sh"},
+		Args:   append([]string{cfg.InitApp}, cfg.InitArgs...),
 		Stdin:  con,
 		Stdout: con,
 		Stderr: con,
 	}
 	ctx.Init()
-	main(ctx)
+	err := app.Run(cfg.InitApp, ctx)
+	if err != nil {
+		fmt.Fprintf(con, "%s: %s\n", cfg.InitApp, err)
+	}
 }
 
 func init() {
@@ -14,13 +14,14 @@ typedef unsigned long long uint64;
 
 #include "elf.h"
 #include "multiboot.h"
+#include "multiboot2.h"
 
 extern char _binary_boot64_elf_start[];
 
 void memcpy(char *dst, char *src, int count);
 void memset(char *addr, char data, int cnt);
 uint64 loadelf(char *image);
-uint64 loadKernelElf(multiboot_info_t *info);
+uint64 loadKernelElf(unsigned long magic, void *info);
 typedef void (*boot64_entry_t)(uint32, uint32, uint32);
 
 void multibootmain(unsigned long magic, multiboot_info_t *mbi)
@@ -35,7 +36,7 @@ void multibootmain(unsigned long magic, multiboot_info_t *mbi)
     }
     boot64_entry = (boot64_entry_t)((uint32)entry_addr);
 
-    entry_addr = loadKernelElf(mbi);
+    entry_addr = loadKernelElf(magic, mbi);
     if (entry_addr == 0)
     {
         return;
@@ -70,15 +71,54 @@ uint64 loadelf(char *image)
     return elf->entry;
 }
 
-uint64 loadKernelElf(multiboot_info_t *info)
+// findModule2 returns the first module tag of the multiboot2 info
+struct multiboot2_tag_module *findModule2(struct multiboot2_info *info)
 {
-    if (info->mods_count < 1)
+    char *end = (char *)info + info->total_size;
+    char *p = (char *)(info + 1);
+    while (p + sizeof(struct multiboot2_tag) <= end)
     {
-        return 0;
+        struct multiboot2_tag *tag = (struct multiboot2_tag *)p;
+        if (tag->type == MULTIBOOT2_TAG_TYPE_END)
+        {
+            break;
+        }
+        if (tag->type == MULTIBOOT2_TAG_TYPE_MODULE)
+        {
+            return (struct multiboot2_tag_module *)tag;
+        }
+        p += (tag->size + MULTIBOOT2_TAG_ALIGN - 1) & ~(MULTIBOOT2_TAG_ALIGN - 1);
+    }
+    return 0;
+}
+
+// loadKernelElf loads the kernel image, which is the first module
+uint64 loadKernelElf(unsigned long magic, void *info)
+{
+    uint32 start, end;
+    if (magic == MULTIBOOT2_BOOTLOADER_MAGIC)
+    {
+        struct multiboot2_tag_module *mod = findModule2((struct multiboot2_info *)info);
+        if (mod == 0)
+        {
+            return 0;
+        }
+        start = mod->mod_start;
+        end = mod->mod_end;
+    }
+    else
+    {
+        multiboot_info_t *mbi = (multiboot_info_t *)info;
+        if (mbi->mods_count < 1)
+        {
+            return 0;
+        }
+        multiboot_module_t *mod = (multiboot_module_t *)(mbi->mods_addr);
+        start = mod->mod_start;
+        end = mod->mod_end;
     }
-    multiboot_module_t *mod = (multiboot_module_t *)(info->mods_addr);
     char *new_addr = (char *)(100 << 20); // 100 MB
-    memcpy(new_addr, (char *)(mod->mod_start), mod->mod_end - mod->mod_start + 1);
+    memcpy(new_addr, (char *)start, end - start + 1);
     return loadelf(new_addr);
 }
 
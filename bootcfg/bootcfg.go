@@ -0,0 +1,195 @@
+// bootcfg parses the kernel command line into typed options.
+//
+// The kernel puts the space separated words of the multiboot command line
+// into the environment, an option is a word like spos_NAME=value:
+//
+//	spos_LOGLVL    debug, info, warn, error or none
+//	spos_CONSOLE   serial, vga or both, where the console writes
+//	spos_DNS       comma separated name servers
+//	spos_MAXPROCS  the Ps of goroutines, GOMAXPROCS has two more for the kernel threads
+//	spos_THREADS   the max number of threads, capped to the size of the thread table
+//	spos_DISABLE   comma separated drivers not to initialize, like mouse,vbe
+//	spos_INIT      the app run at boot, sh by default
+//	spos_INITARGS  comma separated arguments of the init app
+//	spos_GDB       1 enables the gdb stub, wait also breaks at boot
+//	spos_INITRD    where the tar or cpio boot modules go, / by default
+//
+// The network stack isn't started yet, spos_NET, spos_IP and spos_GW are
+// reported as unsupported. spos_DNS only fills /etc/resolv.conf.
+package bootcfg
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const prefix = "spos_"
+
+// Console is the output device of the console
+type Console int
+
+const (
+	ConsoleBoth Console = iota
+	ConsoleSerial
+	ConsoleVGA
+)
+
+// Network is the network configuration
+type Network struct {
+	DNS []net.IP
+}
+
+// Config is the typed kernel command line, the zero values are the defaults
+type Config struct {
+	// one of the level names of the log package, "" is the default level
+	LogLevel string
+	Console  Console
+	Network  Network
+	// the Ps of goroutines, 0 lets the kernel choose
+	MaxProcs int
+	// the max number of threads, 0 keeps the default of the kernel
+	MaxThreads int
+	// the drivers not to initialize
+	DisabledDrivers []string
+	InitApp         string
+	InitArgs        []string
+	// "", "1" or "wait"
+	GDB string
+	// the boot modules are unpacked into the root if it's /, or else mounted read-only here
+	InitrdPath string
+}
+
+var (
+	once   sync.Once
+	config *Config
+	errcfg error
+)
+
+// Get returns the config of the kernel command line, it's parsed on the first call
+func Get() *Config {
+	once.Do(func() {
+		config, errcfg = Parse(os.Environ())
+	})
+	return config
+}
+
+// Err returns the error of the options ignored by Get
+func Err() error {
+	Get()
+	return errcfg
+}
+
+// Disabled reports whether the driver name is disabled
+func (c *Config) Disabled(name string) bool {
+	for _, d := range c.DisabledDrivers {
+		if d == name {
+			return true
+		}
+	}
+	return false
+}
+
+func splitList(s string) []string {
+	var l []string
+	for _, v := range strings.Split(s, ",") {
+		if v != "" {
+			l = append(l, v)
+		}
+	}
+	return l
+}
+
+func parseIP(s string) (net.IP, error) {
+	ip := net.ParseIP(s).To4()
+	if ip == nil {
+		return nil, fmt.Errorf("bad ipv4 address %q", s)
+	}
+	return ip, nil
+}
+
+func (c *Config) set(key, value string) error {
+	var err error
+	switch key {
+	case "LOGLVL":
+		switch value {
+		case "debug", "info", "warn", "error", "none":
+			c.LogLevel = value
+		default:
+			return fmt.Errorf("bad log level %q", value)
+		}
+	case "CONSOLE":
+		switch value {
+		case "both":
+			c.Console = ConsoleBoth
+		case "serial":
+			c.Console = ConsoleSerial
+		case "vga":
+			c.Console = ConsoleVGA
+		default:
+			return fmt.Errorf("bad console %q", value)
+		}
+	case "NET", "IP", "GW":
+		return errors.New("unsupported, the network stack isn't started")
+	case "DNS":
+		c.Network.DNS = nil
+		for _, s := range splitList(value) {
+			ip, err := parseIP(s)
+			if err != nil {
+				return err
+			}
+			c.Network.DNS = append(c.Network.DNS, ip)
+		}
+	case "MAXPROCS":
+		c.MaxProcs, err = strconv.Atoi(value)
+		if err != nil || c.MaxProcs < 1 {
+			c.MaxProcs = 0
+			return fmt.Errorf("bad maxprocs %q", value)
+		}
+	case "THREADS":
+		c.MaxThreads, err = strconv.Atoi(value)
+		if err != nil || c.MaxThreads < 1 {
+			c.MaxThreads = 0
+			return fmt.Errorf("bad thread limit %q", value)
+		}
+	case "DISABLE":
+		c.DisabledDrivers = splitList(value)
+	case "INIT":
+		c.InitApp = value
+	case "INITARGS":
+		c.InitArgs = splitList(value)
+	case "GDB":
+		c.GDB = value
+	case "INITRD":
+		if !strings.HasPrefix(value, "/") {
+			return fmt.Errorf("initrd path %q isn't absolute", value)
+		}
+		c.InitrdPath = value
+	}
+	return err
+}
+
+// Parse parses the options in env, which has the form of os.Environ.
+// Words without the spos_ prefix and unknown options are ignored,
+// the bad options are reported in the error but the others are still parsed.
+func Parse(env []string) (*Config, error) {
+	c := &Config{InitApp: "sh", InitrdPath: "/"}
+	var errs []error
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, prefix) {
+			continue
+		}
+		key, value, _ := strings.Cut(kv[len(prefix):], "=")
+		if err := c.set(key, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s%s: %w", prefix, key, err))
+		}
+	}
+	if c.InitApp == "" {
+		c.InitApp = "sh"
+	}
+	return c, errors.Join(errs...)
+}
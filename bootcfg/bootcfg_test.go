@@ -0,0 +1,85 @@
+package bootcfg_test
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/banditmoscow1337/spos/bootcfg"
+)
+
+func TestParse_Defaults(t *testing.T) {
+	c, err := bootcfg.Parse([]string{"TERM=xterm", "quiet"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.InitApp != "sh" || c.InitrdPath != "/" || c.Console != bootcfg.ConsoleBoth || c.MaxProcs != 0 {
+		t.Errorf("unexpected defaults: %+v", c)
+	}
+}
+
+func TestParse_Options(t *testing.T) {
+	c, err := bootcfg.Parse([]string{
+		"spos_LOGLVL=debug",
+		"spos_CONSOLE=serial",
+		"spos_DNS=8.8.8.8,1.1.1.1",
+		"spos_MAXPROCS=3",
+		"spos_THREADS=4096",
+		"spos_DISABLE=mouse,vbe",
+		"spos_INIT=sshd",
+		"spos_INITARGS=-p,2222",
+		"spos_GDB=wait",
+		"spos_INITRD=/srv",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := &bootcfg.Config{
+		LogLevel: "debug",
+		Console:  bootcfg.ConsoleSerial,
+		Network: bootcfg.Network{
+			DNS: []net.IP{net.IPv4(8, 8, 8, 8).To4(), net.IPv4(1, 1, 1, 1).To4()},
+		},
+		MaxProcs:        3,
+		MaxThreads:      4096,
+		DisabledDrivers: []string{"mouse", "vbe"},
+		InitApp:         "sshd",
+		InitArgs:        []string{"-p", "2222"},
+		GDB:             "wait",
+		InitrdPath:      "/srv",
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("got %+v, want %+v", c, want)
+	}
+	if !c.Disabled("mouse") || c.Disabled("kbd") {
+		t.Error("unexpected disabled drivers")
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		env  []string
+	}{
+		{"bad log level", []string{"spos_LOGLVL=verbose"}},
+		{"bad console", []string{"spos_CONSOLE=lcd"}},
+		{"bad dns", []string{"spos_DNS=8.8.8.8,dns"}},
+		{"unsupported network mode", []string{"spos_NET=dhcp"}},
+		{"unsupported address", []string{"spos_IP=10.0.2.15/24"}},
+		{"unsupported gateway", []string{"spos_GW=10.0.2.2"}},
+		{"bad maxprocs", []string{"spos_MAXPROCS=0"}},
+		{"bad thread limit", []string{"spos_THREADS=many"}},
+		{"relative initrd path", []string{"spos_INITRD=srv"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := bootcfg.Parse(append(test.env, "spos_INIT=top"))
+			if err == nil {
+				t.Error("expected error, received none")
+			}
+			// the good options are still parsed
+			if c.InitApp != "top" {
+				t.Errorf("unexpected init app %q", c.InitApp)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"syscall"
 	"unsafe"
 
+	"github.com/banditmoscow1337/spos/bootcfg"
 	"github.com/banditmoscow1337/spos/drivers/cga"
 	"github.com/banditmoscow1337/spos/drivers/kbd"
 	"github.com/banditmoscow1337/spos/drivers/uart"
@@ -27,6 +28,9 @@ type console struct {
 	notify *sync.Cond
 
 	wmutex sync.Mutex
+
+	// the output devices selected by spos_CONSOLE
+	serial, vga bool
 }
 
 var (
@@ -34,10 +38,13 @@ var (
 )
 
 func newConsole() *console {
+	dev := bootcfg.Get().Console
 	c := &console{
 		tios: syscall.Termios{
 			Lflag: syscall.ICANON | syscall.ECHO,
 		},
+		serial: dev != bootcfg.ConsoleVGA,
+		vga:    dev != bootcfg.ConsoleSerial,
 	}
 	c.notify = sync.NewCond(&c.mutex)
 	return c
@@ -107,8 +114,12 @@ func (c *console) loop() {
 }
 
 func (c *console) putc(ch byte) {
-	uart.WriteByte(ch)
-	cga.WriteByte(ch)
+	if c.serial {
+		uart.WriteByte(ch)
+	}
+	if c.vga {
+		cga.WriteByte(ch)
+	}
 }
 
 func (c *console) read(p []byte) int {
@@ -0,0 +1,222 @@
+// Package acpi locates and parses the ACPI tables provided by the firmware.
+//
+// Everything here runs before the go runtime is initialized,
+// so it must not allocate and all functions are nosplit.
+package acpi
+
+import (
+	"unsafe"
+
+	"github.com/banditmoscow1337/spos/drivers/multiboot"
+	"github.com/banditmoscow1337/spos/kernel/mm"
+)
+
+const (
+	// the bios data area stores the segment of the EBDA at 0x40e
+	ebdaSegPtr = 0x40e
+
+	biosROMStart = 0xe0000
+	biosROMEnd   = 0x100000
+
+	maxTables = 32
+)
+
+var (
+	rsdpSignature = [8]byte{'R', 'S', 'D', ' ', 'P', 'T', 'R', ' '}
+
+	enabled bool
+
+	tables  [maxTables]uintptr
+	ntables int
+)
+
+// Header is the common header of all system description tables.
+type Header struct {
+	Signature       [4]byte
+	Length          uint32
+	Revision        uint8
+	Checksum        uint8
+	OEMID           [6]byte
+	OEMTableID      [8]byte
+	OEMRevision     uint32
+	CreatorID       uint32
+	CreatorRevision uint32
+}
+
+type rsdp struct {
+	Signature   [8]byte
+	Checksum    uint8
+	OEMID       [6]byte
+	Revision    uint8
+	RsdtAddress uint32
+
+	// fields below are valid when Revision >= 2
+	Length      uint32
+	XsdtAddress uint64
+	ExtChecksum uint8
+	_           [3]byte
+}
+
+// Enabled reports whether the ACPI tables were found.
+//
+//go:nosplit
+func Enabled() bool {
+	return enabled
+}
+
+//go:nosplit
+func read8(addr uintptr) uint8 {
+	return *(*uint8)(unsafe.Pointer(addr))
+}
+
+//go:nosplit
+func read16(addr uintptr) uint16 {
+	return *(*uint16)(unsafe.Pointer(addr))
+}
+
+//go:nosplit
+func read32(addr uintptr) uint32 {
+	return *(*uint32)(unsafe.Pointer(addr))
+}
+
+//go:nosplit
+func read64(addr uintptr) uint64 {
+	return *(*uint64)(unsafe.Pointer(addr))
+}
+
+//go:nosplit
+func checksum(addr, n uintptr) bool {
+	var sum uint8
+	for i := uintptr(0); i < n; i++ {
+		sum += read8(addr + i)
+	}
+	return sum == 0
+}
+
+//go:nosplit
+func scanRSDP(start, end uintptr) uintptr {
+	for p := start; p+unsafe.Sizeof(rsdp{}) <= end; p += 16 {
+		r := (*rsdp)(unsafe.Pointer(p))
+		if r.Signature != rsdpSignature {
+			continue
+		}
+		if !checksum(p, 20) {
+			continue
+		}
+		return p
+	}
+	return 0
+}
+
+//go:nosplit
+func findRSDP() uintptr {
+	// multiboot2 passes a copy of the RSDP, which is the only way on EFI machines
+	if multiboot.RSDP != 0 {
+		return multiboot.RSDP
+	}
+	ebda := uintptr(read16(ebdaSegPtr)) << 4
+	if ebda != 0 {
+		if p := scanRSDP(ebda, ebda+1024); p != 0 {
+			return p
+		}
+	}
+	return scanRSDP(biosROMStart, biosROMEnd)
+}
+
+// mapTable makes the whole table at addr accessible and validates it.
+//
+//go:nosplit
+func mapTable(addr uintptr) *Header {
+	mm.IdentityMap(addr, unsafe.Sizeof(Header{}))
+	h := (*Header)(unsafe.Pointer(addr))
+	mm.IdentityMap(addr, uintptr(h.Length))
+	if !checksum(addr, uintptr(h.Length)) {
+		return nil
+	}
+	return h
+}
+
+//go:nosplit
+func addTable(addr uintptr) {
+	if ntables >= maxTables {
+		return
+	}
+	if mapTable(addr) == nil {
+		return
+	}
+	tables[ntables] = addr
+	ntables++
+}
+
+// Table returns the first table with signature sig, or nil if not found.
+//
+//go:nosplit
+func Table(sig string) *Header {
+	for i := 0; i < ntables; i++ {
+		h := (*Header)(unsafe.Pointer(tables[i]))
+		if h.Is(sig) {
+			return h
+		}
+	}
+	return nil
+}
+
+// Is reports whether the table signature is sig.
+//
+//go:nosplit
+func (h *Header) Is(sig string) bool {
+	if len(sig) != len(h.Signature) {
+		return false
+	}
+	for i := 0; i < len(sig); i++ {
+		if h.Signature[i] != sig[i] {
+			return false
+		}
+	}
+	return true
+}
+
+//go:nosplit
+func parseRSDP(p uintptr) {
+	r := (*rsdp)(unsafe.Pointer(p))
+
+	var sdt uintptr
+	var entsize uintptr
+	if r.Revision >= 2 && r.XsdtAddress != 0 {
+		sdt, entsize = uintptr(r.XsdtAddress), 8
+	} else {
+		sdt, entsize = uintptr(r.RsdtAddress), 4
+	}
+	h := mapTable(sdt)
+	if h == nil {
+		return
+	}
+	enabled = true
+	end := sdt + uintptr(h.Length)
+	for ent := sdt + unsafe.Sizeof(Header{}); ent+entsize <= end; ent += entsize {
+		var addr uintptr
+		if entsize == 8 {
+			addr = uintptr(read64(ent))
+		} else {
+			addr = uintptr(read32(ent))
+		}
+		if addr != 0 {
+			addTable(addr)
+		}
+	}
+}
+
+// Init finds the RSDP and records all tables listed in the RSDT/XSDT.
+// It must be called after mm.Init.
+//
+//go:nosplit
+func Init() {
+	p := findRSDP()
+	if p == 0 {
+		return
+	}
+	parseRSDP(p)
+	madtInit()
+	hpetInit()
+	fadtInit()
+}
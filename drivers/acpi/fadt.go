@@ -0,0 +1,108 @@
+package acpi
+
+import (
+	"unsafe"
+
+	"github.com/banditmoscow1337/spos/kernel/mm"
+)
+
+// Address spaces of the generic address structure
+const (
+	SpaceMemory = 0
+	SpaceIO     = 1
+	SpacePCI    = 2
+)
+
+const (
+	// IAPC_BOOT_ARCH, the machine has a 8042 keyboard controller
+	bootArch8042 = 1 << 1
+	// Flags, the reset register is supported
+	flagResetReg = 1 << 10
+)
+
+// GAS is a generic address structure
+type GAS struct {
+	Space  uint8
+	Width  uint8
+	Offset uint8
+	Access uint8
+	Addr   uint64
+}
+
+// FADTInfo holds the fields of the fixed ACPI description table used by power management.
+type FADTInfo struct {
+	SCIInt     uint16
+	SMICmd     uint32
+	ACPIEnable uint8
+	// io ports of the pm1 control registers, PM1bCnt is optional
+	PM1aCnt  uint16
+	PM1bCnt  uint16
+	BootArch uint16
+	Flags    uint32
+
+	ResetReg   GAS
+	ResetValue uint8
+
+	// physical address of the DSDT
+	DSDT uintptr
+}
+
+// FADT is valid when FADT.PM1aCnt != 0
+var FADT FADTInfo
+
+//go:nosplit
+func readGAS(addr uintptr) GAS {
+	return GAS{
+		Space:  read8(addr),
+		Width:  read8(addr + 1),
+		Offset: read8(addr + 2),
+		Access: read8(addr + 3),
+		Addr:   read64(addr + 4),
+	}
+}
+
+//go:nosplit
+func fadtInit() {
+	h := Table("FACP")
+	if h == nil {
+		return
+	}
+	base := uintptr(unsafe.Pointer(h))
+	length := uintptr(h.Length)
+
+	FADT.SCIInt = read16(base + 46)
+	FADT.SMICmd = read32(base + 48)
+	FADT.ACPIEnable = read8(base + 52)
+	FADT.PM1aCnt = uint16(read32(base + 64))
+	FADT.PM1bCnt = uint16(read32(base + 68))
+	FADT.DSDT = uintptr(read32(base + 40))
+	if length >= 116 {
+		FADT.BootArch = read16(base + 109)
+		FADT.Flags = read32(base + 112)
+	}
+	if length >= 129 {
+		FADT.ResetReg = readGAS(base + 116)
+		FADT.ResetValue = read8(base + 128)
+	}
+	// the 64 bit fields of acpi 2.0 take precedence
+	if length >= 148 {
+		if dsdt := read64(base + 140); dsdt != 0 {
+			FADT.DSDT = uintptr(dsdt)
+		}
+	}
+	if length >= 196 {
+		if gas := readGAS(base + 172); gas.Space == SpaceIO && gas.Addr != 0 {
+			FADT.PM1aCnt = uint16(gas.Addr)
+		}
+		if gas := readGAS(base + 184); gas.Space == SpaceIO && gas.Addr != 0 {
+			FADT.PM1bCnt = uint16(gas.Addr)
+		}
+	}
+	if FADT.DSDT != 0 && mapTable(FADT.DSDT) == nil {
+		FADT.DSDT = 0
+	}
+	if FADT.Flags&flagResetReg != 0 && FADT.ResetReg.Space == SpaceMemory && FADT.ResetReg.Addr != 0 {
+		mm.IdentityMap(uintptr(FADT.ResetReg.Addr), 1)
+	}
+	s5Init()
+}
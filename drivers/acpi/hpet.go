@@ -0,0 +1,31 @@
+package acpi
+
+import "unsafe"
+
+// HPETInfo holds the parsed HPET description table.
+type HPETInfo struct {
+	// physical address of the register block
+	Addr    uintptr
+	Number  uint8
+	MinTick uint16
+}
+
+// HPET is valid when HPET.Addr != 0
+var HPET HPETInfo
+
+//go:nosplit
+func hpetInit() {
+	h := Table("HPET")
+	if h == nil {
+		return
+	}
+	base := uintptr(unsafe.Pointer(h))
+	// the base address is a generic address structure at 40,
+	// only system memory space is supported
+	if read8(base+40) != 0 {
+		return
+	}
+	HPET.Addr = uintptr(read64(base + 44))
+	HPET.Number = read8(base + 52)
+	HPET.MinTick = read16(base + 53)
+}
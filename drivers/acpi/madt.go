@@ -0,0 +1,136 @@
+package acpi
+
+import "unsafe"
+
+const (
+	MaxCPUs      = 16
+	MaxIOAPICs   = 8
+	MaxOverrides = 16
+
+	madtEntryLAPIC         = 0
+	madtEntryIOAPIC        = 1
+	madtEntryOverride      = 2
+	madtEntryLAPICOverride = 5
+
+	madtLAPICEnabled = 1 << 0
+
+	// offset of the first interrupt controller structure
+	madtEntriesOffset = 44
+)
+
+// LAPIC describes a processor local APIC reported by the MADT.
+type LAPIC struct {
+	ProcessorID uint8
+	APICID      uint8
+}
+
+// IOAPIC describes an I/O APIC reported by the MADT.
+type IOAPIC struct {
+	ID      uint8
+	Addr    uintptr
+	GSIBase uint32
+}
+
+// flags of the interrupt source override
+const (
+	PolarityMask       = 0x3
+	PolarityActiveHigh = 0x1
+	PolarityActiveLow  = 0x3
+
+	TriggerMask  = 0xc
+	TriggerEdge  = 0x4
+	TriggerLevel = 0xc
+)
+
+// Override maps an ISA irq to a global system interrupt.
+type Override struct {
+	Source uint8
+	GSI    uint32
+	Flags  uint16
+}
+
+// MADTInfo holds the parsed Multiple APIC Description Table.
+type MADTInfo struct {
+	LAPICAddr uintptr
+	Flags     uint32
+
+	CPUs [MaxCPUs]LAPIC
+	NCPU int
+
+	IOAPICs   [MaxIOAPICs]IOAPIC
+	NIOAPIC   int
+	Override  [MaxOverrides]Override
+	NOverride int
+}
+
+// FindOverride returns the override of isa irq, or nil if the irq is identity mapped.
+//
+//go:nosplit
+func (m *MADTInfo) FindOverride(irq uint8) *Override {
+	for i := 0; i < m.NOverride; i++ {
+		if m.Override[i].Source == irq {
+			return &m.Override[i]
+		}
+	}
+	return nil
+}
+
+// MADT is valid when MADT.NCPU != 0
+var MADT MADTInfo
+
+//go:nosplit
+func madtInit() {
+	h := Table("APIC")
+	if h == nil {
+		return
+	}
+	base := uintptr(unsafe.Pointer(h))
+	MADT.LAPICAddr = uintptr(read32(base + 36))
+	MADT.Flags = read32(base + 40)
+
+	end := base + uintptr(h.Length)
+	for p := base + madtEntriesOffset; p+2 <= end; {
+		typ, length := read8(p), uintptr(read8(p+1))
+		if length < 2 {
+			break
+		}
+		switch typ {
+		case madtEntryLAPIC:
+			flags := read32(p + 4)
+			if flags&madtLAPICEnabled == 0 {
+				break
+			}
+			if MADT.NCPU >= MaxCPUs {
+				break
+			}
+			MADT.CPUs[MADT.NCPU] = LAPIC{
+				ProcessorID: read8(p + 2),
+				APICID:      read8(p + 3),
+			}
+			MADT.NCPU++
+		case madtEntryIOAPIC:
+			if MADT.NIOAPIC >= MaxIOAPICs {
+				break
+			}
+			MADT.IOAPICs[MADT.NIOAPIC] = IOAPIC{
+				ID:      read8(p + 2),
+				Addr:    uintptr(read32(p + 4)),
+				GSIBase: read32(p + 8),
+			}
+			MADT.NIOAPIC++
+		case madtEntryOverride:
+			if MADT.NOverride >= MaxOverrides {
+				break
+			}
+			MADT.Override[MADT.NOverride] = Override{
+				Source: read8(p + 3),
+				GSI:    read32(p + 4),
+				Flags:  read16(p + 8),
+			}
+			MADT.NOverride++
+		case madtEntryLAPICOverride:
+			MADT.LAPICAddr = uintptr(read64(p + 4))
+		}
+		p += length
+	}
+}
@@ -0,0 +1,179 @@
+package acpi
+
+import (
+	"unsafe"
+
+	"github.com/banditmoscow1337/spos/kernel/sys"
+)
+
+const (
+	// bits of the pm1 control register
+	pm1SCIEnable = 1 << 0
+	pm1SleepType = 10
+	pm1SleepEn   = 1 << 13
+
+	amlNameOp    = 0x08
+	amlPackageOp = 0x12
+	amlBytePfx   = 0x0a
+
+	kbdStatusPort = 0x64
+	kbdCmdReset   = 0xfe
+
+	pciConfigAddr = 0xcf8
+	pciConfigData = 0xcfc
+)
+
+var (
+	// SLP_TYPa and SLP_TYPb of the \_S5 package
+	s5TypA, s5TypB uint16
+	s5Found        bool
+)
+
+// amlInt decodes a small integer constant at p, it returns the next position
+//
+//go:nosplit
+func amlInt(p uintptr) (uint16, uintptr) {
+	switch op := read8(p); op {
+	case amlBytePfx:
+		return uint16(read8(p + 1)), p + 2
+	default:
+		// ZeroOp, OneOp and the bare byte some firmwares emit
+		return uint16(op), p + 1
+	}
+}
+
+// s5Init finds the \_S5 object in the DSDT without a full aml interpreter,
+// it's a package of SLP_TYPa and SLP_TYPb in all known firmwares.
+//
+//go:nosplit
+func s5Init() {
+	if FADT.DSDT == 0 {
+		return
+	}
+	h := (*Header)(unsafe.Pointer(FADT.DSDT))
+	start := FADT.DSDT + unsafe.Sizeof(Header{})
+	end := FADT.DSDT + uintptr(h.Length)
+	for p := start + 1; p+4 < end; p++ {
+		if read8(p) != '_' || read8(p+1) != 'S' || read8(p+2) != '5' || read8(p+3) != '_' {
+			continue
+		}
+		// NameOp _S5_ or NameOp \_S5_
+		if read8(p-1) != amlNameOp && !(read8(p-1) == '\\' && read8(p-2) == amlNameOp) {
+			continue
+		}
+		q := p + 4
+		if read8(q) != amlPackageOp {
+			continue
+		}
+		q++
+		// skip the PkgLength, bits 6-7 of the lead byte are the count of following bytes
+		q += uintptr(read8(q)>>6) + 1
+		// NumElements
+		q++
+		if q+4 > end {
+			return
+		}
+		s5TypA, q = amlInt(q)
+		s5TypB, _ = amlInt(q)
+		s5Found = true
+		return
+	}
+}
+
+// acpiEnable switches the chipset from legacy mode to acpi mode
+//
+//go:nosplit
+func acpiEnable() {
+	if sys.Inw(FADT.PM1aCnt)&pm1SCIEnable != 0 {
+		return
+	}
+	if FADT.SMICmd == 0 || FADT.ACPIEnable == 0 {
+		return
+	}
+	sys.Outb(uint16(FADT.SMICmd), FADT.ACPIEnable)
+	for i := 0; i < 1000000; i++ {
+		if sys.Inw(FADT.PM1aCnt)&pm1SCIEnable != 0 {
+			return
+		}
+	}
+}
+
+// CanPoweroff reports whether the S5 sleep state is known
+//
+//go:nosplit
+func CanPoweroff() bool {
+	return FADT.PM1aCnt != 0 && s5Found
+}
+
+// Poweroff enters the S5 sleep state, it returns only if it failed
+//
+//go:nosplit
+func Poweroff() {
+	if !CanPoweroff() {
+		return
+	}
+	acpiEnable()
+	v := sys.Inw(FADT.PM1aCnt) &^ (7 << pm1SleepType)
+	sys.Outw(FADT.PM1aCnt, v|s5TypA<<pm1SleepType|pm1SleepEn)
+	if FADT.PM1bCnt != 0 {
+		v = sys.Inw(FADT.PM1bCnt) &^ (7 << pm1SleepType)
+		sys.Outw(FADT.PM1bCnt, v|s5TypB<<pm1SleepType|pm1SleepEn)
+	}
+	// the sleep takes effect after a while
+	for i := 0; i < 1000000; i++ {
+		sys.Inb(0x80)
+	}
+}
+
+// resetReg writes the reset value to the FADT reset register
+//
+//go:nosplit
+func resetReg() {
+	r := &FADT.ResetReg
+	if FADT.Flags&flagResetReg == 0 || r.Addr == 0 {
+		return
+	}
+	switch r.Space {
+	case SpaceMemory:
+		*(*uint8)(unsafe.Pointer(uintptr(r.Addr))) = FADT.ResetValue
+	case SpaceIO:
+		sys.Outb(uint16(r.Addr), FADT.ResetValue)
+	case SpacePCI:
+		// bus 0, device at bits 32-47, function at bits 16-31 and offset at bits 0-15
+		dev := uint32(r.Addr>>32) & 0x1f
+		fn := uint32(r.Addr>>16) & 0x7
+		off := uint32(r.Addr) & 0xff
+		sys.Outl(pciConfigAddr, 1<<31|dev<<11|fn<<8|off&^3)
+		sys.Outb(uint16(pciConfigData+off&3), FADT.ResetValue)
+	}
+}
+
+// resetKbd pulses the reset line through the 8042 keyboard controller
+//
+//go:nosplit
+func resetKbd() {
+	for i := 0; i < 100000; i++ {
+		if sys.Inb(kbdStatusPort)&0x02 == 0 {
+			break
+		}
+	}
+	sys.Outb(kbdStatusPort, kbdCmdReset)
+}
+
+// Reset resets the machine through the FADT reset register,
+// or the keyboard controller. It returns only if both failed.
+//
+//go:nosplit
+func Reset() {
+	resetReg()
+	for i := 0; i < 1000000; i++ {
+		sys.Inb(0x80)
+	}
+	// the 8042 is assumed present on machines without the IAPC_BOOT_ARCH flags
+	if FADT.BootArch == 0 || FADT.BootArch&bootArch8042 != 0 {
+		resetKbd()
+		for i := 0; i < 1000000; i++ {
+			sys.Inb(0x80)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package apic
+
+import (
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/banditmoscow1337/spos/kernel/mm"
+)
+
+const (
+	MaxIOAPICs = 8
+
+	ioregsel = 0x00
+	iowin    = 0x10
+
+	ioapicRegVer   = 0x01
+	ioapicRegRedir = 0x10
+
+	redirMasked    = 1 << 16
+	redirLevelTrig = 1 << 15
+	redirActiveLow = 1 << 13
+)
+
+// Trigger and polarity of an interrupt pin
+const (
+	EdgeHigh  = 0
+	LevelLow  = redirLevelTrig | redirActiveLow
+	LevelHigh = redirLevelTrig
+	EdgeLow   = redirActiveLow
+)
+
+type ioapic struct {
+	base    uintptr
+	gsibase uint32
+	npin    uint32
+}
+
+var (
+	ioapics  [MaxIOAPICs]ioapic
+	nioapic  int
+	destAPIC uint8
+)
+
+//go:nosplit
+func (io *ioapic) read(reg uint32) uint32 {
+	atomic.StoreUint32((*uint32)(unsafe.Pointer(io.base+ioregsel)), reg)
+	return atomic.LoadUint32((*uint32)(unsafe.Pointer(io.base + iowin)))
+}
+
+//go:nosplit
+func (io *ioapic) write(reg, val uint32) {
+	atomic.StoreUint32((*uint32)(unsafe.Pointer(io.base+ioregsel)), reg)
+	atomic.StoreUint32((*uint32)(unsafe.Pointer(io.base+iowin)), val)
+}
+
+// AddIOAPIC maps the IOAPIC at addr serving the global system interrupts
+// starting at gsibase. All pins are masked.
+//
+//go:nosplit
+func AddIOAPIC(addr uintptr, gsibase uint32) {
+	if nioapic >= MaxIOAPICs {
+		return
+	}
+	mm.IdentityMap(addr, mm.PGSIZE)
+	io := &ioapics[nioapic]
+	io.base = addr
+	io.gsibase = gsibase
+	io.npin = (io.read(ioapicRegVer)>>16)&0xff + 1
+	for i := uint32(0); i < io.npin; i++ {
+		io.write(ioapicRegRedir+2*i, redirMasked)
+		io.write(ioapicRegRedir+2*i+1, 0)
+	}
+	nioapic++
+}
+
+// IOAPICEnabled reports whether any IOAPIC has been added.
+//
+//go:nosplit
+func IOAPICEnabled() bool {
+	return nioapic != 0
+}
+
+// SetIRQDest sets the local apic id which receives the irqs routed afterwards.
+//
+//go:nosplit
+func SetIRQDest(apicid uint8) {
+	destAPIC = apicid
+}
+
+// IRQDest returns the local apic id which receives the irqs.
+//
+//go:nosplit
+func IRQDest() uint8 {
+	return destAPIC
+}
+
+//go:nosplit
+func findIOAPIC(gsi uint32) (*ioapic, uint32) {
+	for i := 0; i < nioapic; i++ {
+		io := &ioapics[i]
+		if gsi >= io.gsibase && gsi < io.gsibase+io.npin {
+			return io, gsi - io.gsibase
+		}
+	}
+	return nil, 0
+}
+
+// Route delivers gsi as vector to the irq destination cpu,
+// mode is one of EdgeHigh, LevelLow, LevelHigh and EdgeLow.
+// The pin is left masked.
+//
+//go:nosplit
+func Route(gsi uint32, vector uint8, mode uint32) {
+	io, pin := findIOAPIC(gsi)
+	if io == nil {
+		return
+	}
+	io.write(ioapicRegRedir+2*pin, redirMasked)
+	io.write(ioapicRegRedir+2*pin+1, uint32(destAPIC)<<24)
+	io.write(ioapicRegRedir+2*pin, uint32(vector)|mode|redirMasked)
+}
+
+// Mask stops the delivery of gsi.
+//
+//go:nosplit
+func Mask(gsi uint32) {
+	io, pin := findIOAPIC(gsi)
+	if io == nil {
+		return
+	}
+	reg := ioapicRegRedir + 2*pin
+	io.write(reg, io.read(reg)|redirMasked)
+}
+
+// Unmask resumes the delivery of gsi.
+//
+//go:nosplit
+func Unmask(gsi uint32) {
+	io, pin := findIOAPIC(gsi)
+	if io == nil {
+		return
+	}
+	reg := ioapicRegRedir + 2*pin
+	io.write(reg, io.read(reg)&^redirMasked)
+}
@@ -0,0 +1,193 @@
+// Package apic drives the processor local APIC and the I/O APICs.
+package apic
+
+import (
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/banditmoscow1337/spos/kernel/mm"
+)
+
+const (
+	DefaultLAPICBase = 0xfee00000
+
+	// vectors above all legacy irq lines
+	TimerVector      = 0xf0
+	RescheduleVector = 0xf1
+	SpuriousVector   = 0xff
+)
+
+const (
+	regID        = 0x20
+	regTPR       = 0x80
+	regEOI       = 0xb0
+	regSVR       = 0xf0
+	regESR       = 0x280
+	regICRLow    = 0x300
+	regICRHigh   = 0x310
+	regLVTTimer  = 0x320
+	regLINT0     = 0x350
+	regLINT1     = 0x360
+	regLVTError  = 0x370
+	regTimerInit = 0x380
+	regTimerCurr = 0x390
+	regTimerDiv  = 0x3e0
+
+	svrEnable = 0x100
+
+	lvtMasked   = 1 << 16
+	lvtPeriodic = 1 << 17
+	lvtExtINT   = 0x700
+	lvtNMI      = 0x400
+
+	icrInit      = 0x500
+	icrStartup   = 0x600
+	icrPending   = 1 << 12
+	icrAssert    = 1 << 14
+	icrLevelTrig = 1 << 15
+
+	timerDiv16 = 0x3
+)
+
+var (
+	base uintptr
+)
+
+//go:nosplit
+func read(reg uintptr) uint32 {
+	return atomic.LoadUint32((*uint32)(unsafe.Pointer(base + reg)))
+}
+
+//go:nosplit
+func write(reg uintptr, val uint32) {
+	atomic.StoreUint32((*uint32)(unsafe.Pointer(base+reg)), val)
+	// wait for the write to finish by reading
+	read(regID)
+}
+
+// Enabled reports whether the local APIC has been initialized.
+//
+//go:nosplit
+func Enabled() bool {
+	return base != 0
+}
+
+//go:nosplit
+func enable() {
+	// clear error status and accept all interrupts
+	write(regESR, 0)
+	write(regESR, 0)
+	write(regTPR, 0)
+	write(regSVR, svrEnable|SpuriousVector)
+	write(regLVTTimer, lvtMasked)
+	write(regLVTError, lvtMasked)
+}
+
+// Init maps the local APIC at addr and enables it on the bootstrap processor.
+// The legacy 8259 keeps delivering interrupts through LINT0 (virtual wire mode).
+//
+//go:nosplit
+func Init(addr uintptr) {
+	if addr == 0 {
+		addr = DefaultLAPICBase
+	}
+	mm.IdentityMap(addr, mm.PGSIZE)
+	base = addr
+	enable()
+	write(regLINT0, lvtExtINT)
+	write(regLINT1, lvtNMI)
+}
+
+// DisableVirtualWire masks LINT0 once the legacy irqs are routed by the IOAPIC.
+//
+//go:nosplit
+func DisableVirtualWire() {
+	write(regLINT0, lvtMasked)
+}
+
+// InitAP enables the local APIC of an application processor.
+// Legacy interrupts are only delivered to the bootstrap processor.
+//
+//go:nosplit
+func InitAP() {
+	enable()
+	write(regLINT0, lvtMasked)
+	write(regLINT1, lvtNMI)
+}
+
+// ID returns the local APIC id of the current processor.
+//
+//go:nosplit
+func ID() uint8 {
+	return uint8(read(regID) >> 24)
+}
+
+// EOI signals the end of interrupt to the local APIC.
+//
+//go:nosplit
+func EOI() {
+	write(regEOI, 0)
+}
+
+//go:nosplit
+func sendIPI(apicid uint8, low uint32) {
+	write(regICRHigh, uint32(apicid)<<24)
+	write(regICRLow, low)
+	for read(regICRLow)&icrPending != 0 {
+	}
+}
+
+// SendInit sends an INIT IPI to the processor with apicid.
+//
+//go:nosplit
+func SendInit(apicid uint8) {
+	sendIPI(apicid, icrInit|icrAssert|icrLevelTrig)
+	sendIPI(apicid, icrInit|icrLevelTrig)
+}
+
+// SendStartup sends a STARTUP IPI, the processor starts
+// in real mode at physical address page<<12.
+//
+//go:nosplit
+func SendStartup(apicid uint8, page uint8) {
+	sendIPI(apicid, icrStartup|uint32(page))
+}
+
+// SendFixed sends an IPI of vector to the processor with apicid.
+//
+//go:nosplit
+func SendFixed(apicid uint8, vector uint8) {
+	sendIPI(apicid, uint32(vector)|icrAssert)
+}
+
+// StartTimer arms the local APIC timer with count ticks of the bus clock
+// divided by 16. A masked timer still counts, which is used for calibration.
+//
+//go:nosplit
+func StartTimer(vector uint8, count uint32, periodic, masked bool) {
+	lvt := uint32(vector)
+	if periodic {
+		lvt |= lvtPeriodic
+	}
+	if masked {
+		lvt |= lvtMasked
+	}
+	write(regTimerDiv, timerDiv16)
+	write(regLVTTimer, lvt)
+	write(regTimerInit, count)
+}
+
+// StopTimer disarms the local APIC timer.
+//
+//go:nosplit
+func StopTimer() {
+	write(regLVTTimer, lvtMasked)
+	write(regTimerInit, 0)
+}
+
+// TimerCount returns the current count of the local APIC timer.
+//
+//go:nosplit
+func TimerCount() uint32 {
+	return read(regTimerCurr)
+}
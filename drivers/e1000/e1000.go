@@ -6,12 +6,11 @@ import (
 	"time"
 	"unsafe"
 
-	"github.com/icexin/eggos/drivers/pci"
-	"github.com/icexin/eggos/drivers/pic"
-	"github.com/icexin/eggos/inet"
-	"github.com/icexin/eggos/kernel/mm"
-	"github.com/icexin/eggos/kernel/sys"
-	"github.com/icexin/eggos/log"
+	"github.com/banditmoscow1337/spos/drivers/pci"
+	"github.com/banditmoscow1337/spos/inet"
+	"github.com/banditmoscow1337/spos/kernel/mm"
+	"github.com/banditmoscow1337/spos/kernel/sys"
+	"github.com/banditmoscow1337/spos/log"
 
 	//"github.com/icexin/eggos/gvisor/buffer"
 	"gvisor.dev/gvisor/pkg/tcpip/buffer"
@@ -134,6 +133,9 @@ func (d *driver) Init(dev *pci.Device) error {
 
 	log.Infof("[e1000] enable bus master")
 	dev.Addr.EnableBusMaster()
+	if err := dev.EnableMSI(); err != nil {
+		log.Infof("[e1000] %s, use irq line %d", err, dev.IRQLine)
+	}
 
 	// mmap bar address
 	baddr, blen, _, ismem := dev.Addr.ReadBAR(0)
@@ -250,8 +252,6 @@ func (d *driver) Transmit(pkt *stack.PacketBuffer) error {
 }
 
 func (d *driver) Intr() {
-	defer pic.EnableIRQ(uint16(d.dev.IRQLine))
-	defer pic.EOI(uintptr(d.dev.IRQNO))
 	cause := d.readcmd(REG_ICR)
 	// log.Infof("[e1000] cause %x", cause)
 	// clear ICR register
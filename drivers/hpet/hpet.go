@@ -0,0 +1,78 @@
+// Package hpet uses the main counter of the High Precision Event Timer as a clocksource.
+package hpet
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/banditmoscow1337/spos/kernel/mm"
+)
+
+const (
+	regCap     = 0x00
+	regConfig  = 0x10
+	regCounter = 0xf0
+
+	cfgEnable = 1 << 0
+
+	// the spec requires a period no more than 100ns
+	maxPeriodFs = 100000000
+	fsPerNs     = 1000000
+)
+
+var (
+	base uintptr
+	// counter period in femtoseconds
+	period uint64
+)
+
+//go:nosplit
+func read(reg uintptr) uint64 {
+	return atomic.LoadUint64((*uint64)(unsafe.Pointer(base + reg)))
+}
+
+//go:nosplit
+func write(reg uintptr, val uint64) {
+	atomic.StoreUint64((*uint64)(unsafe.Pointer(base+reg)), val)
+}
+
+// Init maps the HPET at addr and starts the main counter,
+// it returns false if the HPET reports an invalid period.
+//
+//go:nosplit
+func Init(addr uintptr) bool {
+	mm.IdentityMap(addr, mm.PGSIZE)
+	base = addr
+	p := read(regCap) >> 32
+	if p == 0 || p > maxPeriodFs {
+		base = 0
+		return false
+	}
+	period = p
+	write(regConfig, read(regConfig)|cfgEnable)
+	return true
+}
+
+// Enabled reports whether the HPET is used.
+//
+//go:nosplit
+func Enabled() bool {
+	return base != 0
+}
+
+// Counter returns the main counter.
+//
+//go:nosplit
+func Counter() uint64 {
+	return read(regCounter)
+}
+
+// Nanosecond returns the main counter in nanoseconds.
+//
+//go:nosplit
+func Nanosecond() int64 {
+	hi, lo := bits.Mul64(Counter(), period)
+	ns, _ := bits.Div64(hi, lo, fsPerNs)
+	return int64(ns)
+}
@@ -0,0 +1,203 @@
+// Package irq routes the legacy irq lines to the bootstrap processor.
+//
+// The IOAPIC is used when the MADT reports one, the 8259 PIC is only a fallback.
+// Either way irq line n is delivered as vector IRQ_BASE+n, so trap handlers
+// registered with trap.Register do not depend on the interrupt controller.
+package irq
+
+import (
+	"github.com/banditmoscow1337/spos/drivers/acpi"
+	"github.com/banditmoscow1337/spos/drivers/apic"
+	"github.com/banditmoscow1337/spos/drivers/pic"
+)
+
+const (
+	IRQ_BASE = pic.IRQ_BASE
+	// number of irq lines, the vectors above IRQ_BASE+NR_IRQS are not irqs
+	NR_IRQS = 64
+
+	nrISAIRQS = 16
+
+	// lines above msiBase have no IOAPIC pin, they are allocated to MSI vectors
+	msiBase = 32
+)
+
+var (
+	useAPIC bool
+	// bit n is set if line n has been enabled
+	enabled uint64
+	// bit n is set if line n has been allocated by AllocVector
+	allocated uint64
+)
+
+// Init selects the interrupt controller, acpi.Init must be called before.
+//
+//go:nosplit
+func Init() {
+	// remap the 8259 even if it's not used, so spurious irqs
+	// don't land on the exception vectors
+	pic.Init()
+
+	madt := &acpi.MADT
+	if madt.NIOAPIC == 0 {
+		return
+	}
+	apic.Init(madt.LAPICAddr)
+	apic.SetIRQDest(apic.ID())
+	for i := 0; i < madt.NIOAPIC; i++ {
+		apic.AddIOAPIC(madt.IOAPICs[i].Addr, madt.IOAPICs[i].GSIBase)
+	}
+	if !apic.IOAPICEnabled() {
+		return
+	}
+	for i := uint16(0); i < nrISAIRQS; i++ {
+		pic.DisableIRQ(i)
+	}
+	apic.DisableVirtualWire()
+	useAPIC = true
+}
+
+// UseAPIC reports whether irqs are routed by the IOAPIC.
+//
+//go:nosplit
+func UseAPIC() bool {
+	return useAPIC
+}
+
+// lineGSI returns the global system interrupt and the pin mode of line.
+// ISA irqs default to edge triggered and active high, PCI irqs
+// to level triggered and active low, the MADT overrides both.
+//
+//go:nosplit
+func lineGSI(line uint16, level bool) (uint32, uint32) {
+	mode := uint32(apic.EdgeHigh)
+	if level {
+		mode = apic.LevelLow
+	}
+	ov := acpi.MADT.FindOverride(uint8(line))
+	if line >= nrISAIRQS || ov == nil {
+		return uint32(line), mode
+	}
+
+	polarity := mode & apic.EdgeLow
+	switch ov.Flags & acpi.PolarityMask {
+	case acpi.PolarityActiveHigh:
+		polarity = 0
+	case acpi.PolarityActiveLow:
+		polarity = apic.EdgeLow
+	}
+	trigger := mode & apic.LevelHigh
+	switch ov.Flags & acpi.TriggerMask {
+	case acpi.TriggerEdge:
+		trigger = 0
+	case acpi.TriggerLevel:
+		trigger = apic.LevelHigh
+	}
+	return ov.GSI, polarity | trigger
+}
+
+//go:nosplit
+func enable(line uint16, level bool) {
+	if line >= NR_IRQS {
+		return
+	}
+	enabled |= 1 << line
+	if !useAPIC {
+		pic.EnableIRQ(line)
+		return
+	}
+	gsi, mode := lineGSI(line, level)
+	apic.Route(gsi, uint8(IRQ_BASE+line), mode)
+	apic.Unmask(gsi)
+}
+
+// Enable enables the edge triggered ISA irq line.
+//
+//go:nosplit
+func Enable(line uint16) {
+	enable(line, false)
+}
+
+// EnableLevel enables the level triggered irq line, used by PCI devices.
+//
+//go:nosplit
+func EnableLevel(line uint16) {
+	enable(line, true)
+}
+
+// Disable disables line until it's enabled again.
+//
+//go:nosplit
+func Disable(line uint16) {
+	Mask(line)
+	if line < NR_IRQS {
+		enabled &^= 1 << line
+	}
+}
+
+// Mask temporarily stops the delivery of an enabled line.
+//
+//go:nosplit
+func Mask(line uint16) {
+	if line >= NR_IRQS || enabled&(1<<line) == 0 {
+		return
+	}
+	if !useAPIC {
+		pic.DisableIRQ(line)
+		return
+	}
+	gsi, _ := lineGSI(line, false)
+	apic.Mask(gsi)
+}
+
+// Unmask resumes the delivery of a line stopped by Mask.
+//
+//go:nosplit
+func Unmask(line uint16) {
+	if line >= NR_IRQS || enabled&(1<<line) == 0 {
+		return
+	}
+	if !useAPIC {
+		pic.EnableIRQ(line)
+		return
+	}
+	gsi, _ := lineGSI(line, false)
+	apic.Unmask(gsi)
+}
+
+// AllocVector allocates a vector for message signaled interrupts,
+// it returns false if the IOAPIC is not used or the vectors run out.
+// The line of the vector is never masked, Mask and Unmask are no-ops for it.
+func AllocVector() (uint8, bool) {
+	if !useAPIC {
+		return 0, false
+	}
+	for line := uint(msiBase); line < NR_IRQS; line++ {
+		if allocated&(1<<line) == 0 {
+			allocated |= 1 << line
+			return uint8(IRQ_BASE + line), true
+		}
+	}
+	return 0, false
+}
+
+// EOI signals the end of the interrupt with vector.
+//
+//go:nosplit
+func EOI(vector uintptr) {
+	if useAPIC {
+		apic.EOI()
+		return
+	}
+	pic.EOI(vector)
+}
+
+// Ack masks the line of vector and signals the end of interrupt,
+// the line must be unmasked after the irq is handled.
+// Level triggered irqs would fire again right after the EOI otherwise.
+//
+//go:nosplit
+func Ack(vector uintptr) {
+	Mask(uint16(vector - IRQ_BASE))
+	EOI(vector)
+}
@@ -1,6 +1,7 @@
 package kbd
 
 import (
+	"github.com/banditmoscow1337/spos/drivers/irq"
 	"github.com/banditmoscow1337/spos/drivers/pic"
 	"github.com/banditmoscow1337/spos/kernel/sys"
 	"github.com/banditmoscow1337/spos/kernel/trap"
@@ -219,7 +220,6 @@ func intr() {
 			}
 		}
 	}
-	pic.EOI(_IRQ_KBD)
 }
 
 func OnInput(callback func(byte)) {
@@ -232,5 +232,5 @@ func Pressed(key byte) bool {
 
 func Init() {
 	trap.Register(_IRQ_KBD, intr)
-	pic.EnableIRQ(pic.LINE_KBD)
+	irq.Enable(pic.LINE_KBD)
 }
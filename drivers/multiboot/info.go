@@ -8,8 +8,9 @@ const (
 	bootloaderMagic = 0x2BADB002
 )
 
+// types of the memory map entries
 const (
-	MemoryAvailable = 1 << iota
+	MemoryAvailable = iota + 1
 	MemoryReserved
 	MemoryACPIReclaimable
 	MemoryNVS
@@ -77,14 +78,40 @@ type Info struct {
 	ColorInfo         [6]byte
 }
 
+// MmapEntries returns the memory map decoded by Init, it's the same for multiboot2
+//
+//go:nosplit
 func (i *Info) MmapEntries() []MmapEntry {
-	n := i.MmapLength / uint32(unsafe.Sizeof(MmapEntry{}))
-	return (*[128]MmapEntry)(unsafe.Pointer(uintptr(i.MmapAddr)))[:n]
+	return mmapEntries[:nmmap]
 }
 
+// MmapEntry is a decoded entry of the memory map, the entries of the
+// bootloader are packed so they can't be used directly.
 type MmapEntry struct {
 	Size uint32
 	Addr uint64
 	Len  uint64
 	Type uint32
 }
+
+// Module is a boot module loaded by the bootloader at [Start, End)
+type Module struct {
+	Start, End uintptr
+	// address of the nul terminated command line of the module
+	Cmdline uintptr
+}
+
+//go:nosplit
+func read8(addr uintptr) uint8 {
+	return *(*uint8)(unsafe.Pointer(addr))
+}
+
+//go:nosplit
+func read32(addr uintptr) uint32 {
+	return *(*uint32)(unsafe.Pointer(addr))
+}
+
+//go:nosplit
+func read64(addr uintptr) uint64 {
+	return *(*uint64)(unsafe.Pointer(addr))
+}
@@ -2,20 +2,91 @@ package multiboot
 
 import "unsafe"
 
+const (
+	maxMmapEntries = 128
+	maxModules     = 16
+)
+
 var (
 	enabled  bool
 	BootInfo Info
+
+	// the version of the boot protocol, 1 or 2
+	Version int
+
+	// physical address of the acpi RSDP, 0 if the bootloader didn't pass it
+	RSDP uintptr
+	// physical addresses of the EFI system table and the image handle, 0 if not booted by EFI
+	EFISystemTable uintptr
+	EFIImageHandle uintptr
+
+	mmapEntries [maxMmapEntries]MmapEntry
+	nmmap       int
+	modules     [maxModules]Module
+	nmodules    int
 )
 
+//go:nosplit
 func Enabled() bool {
 	return enabled
 }
 
+// Modules returns the boot modules, the first one is the kernel image
+//
+//go:nosplit
+func Modules() []Module {
+	return modules[:nmodules]
+}
+
+//go:nosplit
+func addMmapEntry(addr, length uint64, typ uint32) {
+	if nmmap == len(mmapEntries) {
+		return
+	}
+	mmapEntries[nmmap] = MmapEntry{Size: 20, Addr: addr, Len: length, Type: typ}
+	nmmap++
+}
+
+//go:nosplit
+func addModule(start, end, cmdline uintptr) {
+	if nmodules == len(modules) {
+		return
+	}
+	modules[nmodules] = Module{Start: start, End: end, Cmdline: cmdline}
+	nmodules++
+}
+
+//go:nosplit
 func Init(magic uintptr, mbiptr uintptr) {
-	if magic != bootloaderMagic {
+	switch magic {
+	case bootloaderMagic:
+		initV1(mbiptr)
+	case bootloader2Magic:
+		initV2(mbiptr)
+	default:
 		return
 	}
 	enabled = true
+}
+
+//go:nosplit
+func initV1(mbiptr uintptr) {
+	Version = 1
 	mbi := (*Info)(unsafe.Pointer(mbiptr))
 	BootInfo = *mbi
+	if BootInfo.Flags&FlagInfoMemMap != 0 {
+		p := uintptr(BootInfo.MmapAddr)
+		end := p + uintptr(BootInfo.MmapLength)
+		// the size field doesn't count itself
+		for ; p < end; p += uintptr(read32(p)) + 4 {
+			addMmapEntry(read64(p+4), read64(p+12), read32(p+20))
+		}
+	}
+	if BootInfo.Flags&FlagInfoMods != 0 {
+		p := uintptr(BootInfo.ModsAddr)
+		for i := uint32(0); i < BootInfo.ModsCount; i++ {
+			addModule(uintptr(read32(p)), uintptr(read32(p+4)), uintptr(read32(p+8)))
+			p += 16
+		}
+	}
 }
@@ -0,0 +1,95 @@
+package multiboot
+
+const (
+	bootloader2Magic = 0x36d76289
+)
+
+// types of the multiboot2 information tags
+const (
+	tagEnd            = 0
+	tagCmdline        = 1
+	tagBootLoaderName = 2
+	tagModule         = 3
+	tagBasicMeminfo   = 4
+	tagMmap           = 6
+	tagFramebuffer    = 8
+	tagEFI32          = 11
+	tagEFI64          = 12
+	tagACPIOld        = 14
+	tagACPINew        = 15
+	tagEFI32Image     = 19
+	tagEFI64Image     = 20
+)
+
+// initV2 walks the multiboot2 tags, the common information is stored in
+// BootInfo as multiboot v1 would pass it so the users needn't care about the version.
+//
+//go:nosplit
+func initV2(mbiptr uintptr) {
+	Version = 2
+	end := mbiptr + uintptr(read32(mbiptr))
+	// the tags are 8 bytes aligned after the total_size and reserved fields
+	for p := mbiptr + 8; p+8 <= end; p += (uintptr(read32(p+4)) + 7) &^ 7 {
+		typ, size := read32(p), read32(p+4)
+		if typ == tagEnd || size < 8 {
+			break
+		}
+		parseTag(p, typ, size)
+	}
+}
+
+//go:nosplit
+func parseTag(p uintptr, typ, size uint32) {
+	info := &BootInfo
+	switch typ {
+	case tagCmdline:
+		info.Flags |= FlagInfoCmdline
+		info.Cmdline = uint32(p + 8)
+	case tagBootLoaderName:
+		info.Flags |= FlagInfoBootLoaderName
+		info.BootLoaderName = uint32(p + 8)
+	case tagModule:
+		info.Flags |= FlagInfoMods
+		info.ModsCount++
+		addModule(uintptr(read32(p+8)), uintptr(read32(p+12)), p+16)
+	case tagBasicMeminfo:
+		info.Flags |= FlagInfoMemory
+		info.MemLower = read32(p + 8)
+		info.MemUpper = read32(p + 12)
+	case tagMmap:
+		info.Flags |= FlagInfoMemMap
+		entrySize := uintptr(read32(p + 8))
+		if entrySize == 0 {
+			return
+		}
+		for e := p + 16; e+entrySize <= p+uintptr(size); e += entrySize {
+			addMmapEntry(read64(e), read64(e+8), read32(e+16))
+		}
+	case tagFramebuffer:
+		info.Flags |= FlagInfoVideoInfo | FlagInfoFrameBuffer
+		info.FramebufferAddr = read64(p + 8)
+		info.FramebufferPitch = read32(p + 16)
+		info.FramebufferWidth = read32(p + 20)
+		info.FramebufferHeight = read32(p + 24)
+		info.FramebufferBPP = read8(p + 28)
+		info.FramebufferType = read8(p + 29)
+		for i := range info.ColorInfo {
+			info.ColorInfo[i] = read8(p + 32 + uintptr(i))
+		}
+	case tagACPIOld:
+		// the RSDP of acpi 2.0 takes precedence
+		if RSDP == 0 {
+			RSDP = p + 8
+		}
+	case tagACPINew:
+		RSDP = p + 8
+	case tagEFI32:
+		EFISystemTable = uintptr(read32(p + 8))
+	case tagEFI64:
+		EFISystemTable = uintptr(read64(p + 8))
+	case tagEFI32Image:
+		EFIImageHandle = uintptr(read32(p + 8))
+	case tagEFI64Image:
+		EFIImageHandle = uintptr(read64(p + 8))
+	}
+}
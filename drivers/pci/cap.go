@@ -0,0 +1,60 @@
+package pci
+
+const (
+	CapPM   = 0x01
+	CapMSI  = 0x05
+	CapPCIe = 0x10
+	CapMSIX = 0x11
+
+	statusCapList = 1 << 4
+
+	// a malformed capability list may loop
+	maxCaps = 48
+)
+
+// Capability is an entry of the capability list in the config space.
+type Capability struct {
+	ID     uint8
+	Offset uint8
+}
+
+func (a Address) readConfig16(reg uint8) uint16 {
+	v := a.ReadPCIRegister(reg &^ 0x3)
+	return uint16(v >> ((reg & 0x2) * 8))
+}
+
+func (a Address) writeConfig16(reg uint8, val uint16) {
+	shift := (reg & 0x2) * 8
+	v := a.ReadPCIRegister(reg &^ 0x3)
+	v = v&^(0xffff<<shift) | uint32(val)<<shift
+	a.WritePCIRegister(reg&^0x3, v)
+}
+
+// Capabilities walks the capability list of the device.
+func (a Address) Capabilities() []Capability {
+	if a.ReadStatus()&statusCapList == 0 {
+		return nil
+	}
+	var caps []Capability
+	off := a.ReadCapOffset()
+	for i := 0; off != 0 && i < maxCaps; i++ {
+		v := a.ReadPCIRegister(off)
+		caps = append(caps, Capability{
+			ID:     uint8(v),
+			Offset: off,
+		})
+		off = uint8(v>>8) &^ 0x3
+	}
+	return caps
+}
+
+// FindCapability returns the config space offset of the capability id,
+// or 0 if the device doesn't have it.
+func (a Address) FindCapability(id uint8) uint8 {
+	for _, c := range a.Capabilities() {
+		if c.ID == id {
+			return c.Offset
+		}
+	}
+	return 0
+}
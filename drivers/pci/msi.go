@@ -0,0 +1,126 @@
+package pci
+
+import (
+	"errors"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/banditmoscow1337/spos/drivers/apic"
+	"github.com/banditmoscow1337/spos/drivers/irq"
+	"github.com/banditmoscow1337/spos/kernel/mm"
+)
+
+const (
+	msiAddrBase = 0xfee00000
+
+	msiCtrlEnable = 1 << 0
+	msiCtrlMME    = 0x7 << 4
+	msiCtrl64Bit  = 1 << 7
+
+	msixCtrlMask   = 1 << 14
+	msixCtrlEnable = 1 << 15
+	msixTableSize  = 0x7ff
+	msixEntrySize  = 16
+
+	cmdIntxDisable = 1 << 10
+)
+
+var (
+	ErrNoMSI    = errors.New("pci: MSI not supported")
+	ErrNoVector = errors.New("pci: no free interrupt vector")
+	ErrBadBAR   = errors.New("pci: unsupported MSI-X table BAR")
+)
+
+// msiMessage returns the address and data delivering vector to the irq destination cpu,
+// fixed delivery mode and edge triggered.
+func msiMessage(vector uint8) (uint32, uint32) {
+	return msiAddrBase | uint32(apic.IRQDest())<<12, uint32(vector)
+}
+
+func (d *Device) disableIntx() {
+	cmd := d.Addr.ReadPCIRegister(0x04)
+	d.Addr.WritePCIRegister(0x04, cmd|cmdIntxDisable)
+}
+
+// EnableMSI allocates a vector and enables the single message MSI of the device,
+// the legacy irq line is disabled and IRQNO is updated to the new vector.
+// Called in Driver.Init, pci.Init registers Driver.Intr on IRQNO afterwards.
+func (d *Device) EnableMSI() error {
+	off := d.Addr.FindCapability(CapMSI)
+	if off == 0 {
+		return ErrNoMSI
+	}
+	vector, ok := irq.AllocVector()
+	if !ok {
+		return ErrNoVector
+	}
+	addr, data := msiMessage(vector)
+
+	ctrl := d.Addr.readConfig16(off + 2)
+	d.Addr.WritePCIRegister(off+4, addr)
+	if ctrl&msiCtrl64Bit != 0 {
+		d.Addr.WritePCIRegister(off+8, 0)
+		d.Addr.writeConfig16(off+12, uint16(data))
+	} else {
+		d.Addr.writeConfig16(off+8, uint16(data))
+	}
+	// only one message
+	ctrl &^= msiCtrlMME
+	d.Addr.writeConfig16(off+2, ctrl|msiCtrlEnable)
+
+	d.disableIntx()
+	d.IRQNO = vector
+	d.msi = true
+	return nil
+}
+
+// EnableMSIX allocates n vectors for the first n entries of the MSI-X table and enables MSI-X.
+// IRQNO is updated to the first vector, the handlers of the other vectors
+// are registered by the driver with trap.Register.
+func (d *Device) EnableMSIX(n int) ([]uint8, error) {
+	off := d.Addr.FindCapability(CapMSIX)
+	if off == 0 {
+		return nil, ErrNoMSI
+	}
+	ctrl := d.Addr.readConfig16(off + 2)
+	if n > int(ctrl&msixTableSize)+1 {
+		n = int(ctrl&msixTableSize) + 1
+	}
+
+	tableReg := d.Addr.ReadPCIRegister(off + 4)
+	bar, _, _, isMem := d.Addr.ReadBAR(uint8(tableReg & 0x7))
+	if !isMem || bar == 0 {
+		return nil, ErrBadBAR
+	}
+	table := uintptr(bar) + uintptr(tableReg&^0x7)
+	size := uintptr(n * msixEntrySize)
+	start := table &^ (mm.PGSIZE - 1)
+	mm.SysFixedMmap(start, start, table+size-start)
+
+	// mask all vectors while programming the table
+	d.Addr.writeConfig16(off+2, ctrl|msixCtrlEnable|msixCtrlMask)
+	vectors := make([]uint8, 0, n)
+	for i := 0; i < n; i++ {
+		vector, ok := irq.AllocVector()
+		if !ok {
+			break
+		}
+		addr, data := msiMessage(vector)
+		ent := table + uintptr(i*msixEntrySize)
+		atomic.StoreUint32((*uint32)(unsafe.Pointer(ent)), addr)
+		atomic.StoreUint32((*uint32)(unsafe.Pointer(ent+4)), 0)
+		atomic.StoreUint32((*uint32)(unsafe.Pointer(ent+8)), data)
+		atomic.StoreUint32((*uint32)(unsafe.Pointer(ent+12)), 0)
+		vectors = append(vectors, vector)
+	}
+	if len(vectors) == 0 {
+		d.Addr.writeConfig16(off+2, ctrl&^msixCtrlEnable)
+		return nil, ErrNoVector
+	}
+	d.Addr.writeConfig16(off+2, (ctrl|msixCtrlEnable)&^msixCtrlMask)
+
+	d.disableIntx()
+	d.IRQNO = vectors[0]
+	d.msi = true
+	return vectors, nil
+}
@@ -1,7 +1,7 @@
 package pci
 
 import (
-	"github.com/banditmoscow1337/spos/drivers/pic"
+	"github.com/banditmoscow1337/spos/drivers/irq"
 	"github.com/banditmoscow1337/spos/kernel/trap"
 	"github.com/banditmoscow1337/spos/log"
 )
@@ -19,6 +19,9 @@ type Device struct {
 
 	IRQLine uint8
 	IRQNO   uint8
+
+	// IRQNO is a MSI or MSI-X vector instead of the legacy line
+	msi bool
 }
 
 var devices []*Device
@@ -49,7 +52,7 @@ func Scan() []*Device {
 					Class:    uint8((class >> 8) & 0xff),
 					SubClass: uint8(class & 0xff),
 					IRQLine:  irqline,
-					IRQNO:    pic.IRQ_BASE + irqline,
+					IRQNO:    irq.IRQ_BASE + irqline,
 				}
 				devices = append(devices, device)
 			}
@@ -79,7 +82,9 @@ func Init() {
 		}
 		log.Infof("[pci] found %x:%x for %s, irq:%d\n", dev.Ident.Vendor, dev.Ident.Device, driver.Name(), dev.IRQNO)
 		driver.Init(dev)
-		pic.EnableIRQ(uint16(dev.IRQLine))
 		trap.Register(int(dev.IRQNO), driver.Intr)
+		if !dev.msi {
+			irq.EnableLevel(uint16(dev.IRQLine))
+		}
 	}
 }
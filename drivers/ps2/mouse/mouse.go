@@ -1,6 +1,7 @@
 package mouse
 
 import (
+	"github.com/banditmoscow1337/spos/drivers/irq"
 	"github.com/banditmoscow1337/spos/drivers/pic"
 	"github.com/banditmoscow1337/spos/drivers/ps2"
 	"github.com/banditmoscow1337/spos/kernel/trap"
@@ -38,7 +39,6 @@ func RightClick() bool {
 }
 
 func intr() {
-	pic.EOI(_IRQ_MOUSE)
 	for {
 		st := ps2.ReadCmd()
 		// log.Infof("status:%08b", st)
@@ -130,7 +130,7 @@ func Init() {
 	ps2.WriteMouseData(0xF4)
 
 	trap.Register(_IRQ_MOUSE, intr)
-	pic.EnableIRQ(pic.LINE_MOUSE)
+	irq.Enable(pic.LINE_MOUSE)
 
 	eventch = make(chan Packet, 10)
 }
@@ -1,38 +1,83 @@
 package uart
 
 import (
+	"github.com/banditmoscow1337/spos/drivers/irq"
 	"github.com/banditmoscow1337/spos/drivers/pic"
 	"github.com/banditmoscow1337/spos/kernel/sys"
 	"github.com/banditmoscow1337/spos/kernel/trap"
 )
 
+// Port is the io base of a 16550 compatible serial port
+type Port uint16
+
 const (
-	com1      = uint16(0x3f8)
+	COM1 Port = 0x3f8
+	COM2 Port = 0x2f8
+
+	com1      = COM1
 	_IRQ_COM1 = pic.IRQ_BASE + pic.LINE_COM1
+
+	_BAUD_BASE = 115200
 )
 
 var (
 	inputCallback func(byte)
 )
 
+// Setup programs the line of p to baud 8n1 with fifo disabled,
+// the receive interrupt is enabled if intr is true.
+//
 //go:nosplit
-func ReadByte() int {
-	if sys.Inb(com1+5)&0x01 == 0 {
+func (p Port) Setup(baud int, intr bool) {
+	base := uint16(p)
+	sys.Outb(base+3, 0x80) // unlock divisor
+	sys.Outb(base+0, uint8(_BAUD_BASE/baud))
+	sys.Outb(base+1, 0)
+
+	sys.Outb(base+3, 0x03) // lock divisor
+	// disable fifo
+	sys.Outb(base+2, 0)
+
+	sys.Outb(base+4, 0x00)
+	if intr {
+		sys.Outb(base+1, 0x01)
+	} else {
+		sys.Outb(base+1, 0x00)
+	}
+}
+
+// Getc returns -1 if no data ready
+//
+//go:nosplit
+func (p Port) Getc() int {
+	base := uint16(p)
+	if sys.Inb(base+5)&0x01 == 0 {
 		return -1
 	}
-	return int(sys.Inb(com1 + 0))
+	return int(sys.Inb(base + 0))
 }
 
 //go:nosplit
-func WriteByte(ch byte) {
+func (p Port) Putc(ch byte) {
 	const lstatus = uint16(5)
+	base := uint16(p)
 	for {
-		ret := sys.Inb(com1 + lstatus)
+		ret := sys.Inb(base + lstatus)
 		if ret&0x20 != 0 {
 			break
 		}
 	}
-	sys.Outb(com1, uint8(ch))
+	sys.Outb(base, uint8(ch))
+}
+
+//go:nosplit
+func ReadByte() int {
+	return com1.Getc()
+}
+
+//go:nosplit
+func WriteByte(ch byte) {
+	com1.Putc(ch)
 }
 
 //go:nosplit
@@ -63,22 +108,11 @@ func intr() {
 		}
 		inputCallback(byte(ch))
 	}
-	pic.EOI(_IRQ_COM1)
 }
 
 //go:nosplit
 func PreInit() {
-	sys.Outb(com1+3, 0x80) // unlock divisor
-	sys.Outb(com1+0, 115200/9600)
-	sys.Outb(com1+1, 0)
-
-	sys.Outb(com1+3, 0x03) // lock divisor
-	// disable fifo
-	sys.Outb(com1+2, 0)
-
-	// enable receive interrupt
-	sys.Outb(com1+4, 0x00)
-	sys.Outb(com1+1, 0x01)
+	com1.Setup(9600, true)
 }
 
 func OnInput(callback func(byte)) {
@@ -87,5 +121,5 @@ func OnInput(callback func(byte)) {
 
 func Init() {
 	trap.Register(_IRQ_COM1, intr)
-	pic.EnableIRQ(pic.LINE_COM1)
+	irq.Enable(pic.LINE_COM1)
 }
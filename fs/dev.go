@@ -1,6 +1,10 @@
 package fs
 
-import "math/rand"
+import (
+	"github.com/banditmoscow1337/spos/fs/devfs"
+
+	"golang.org/x/sys/unix"
+)
 
 type zero struct{}
 
@@ -11,8 +15,38 @@ func (z zero) Read(b []byte) (int, error) {
 	return len(b), nil
 }
 
+type null struct{}
+
+func (n null) Read(b []byte) (int, error) {
+	return 0, nil
+}
+
+func (n null) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// random reads from the kernel crng, it never blocks
+// so random and urandom are the same.
 type random struct{}
 
 func (r random) Read(b []byte) (int, error) {
-	return rand.Read(b)
+	return unix.Getrandom(b, 0)
+}
+
+// writes are accepted and discarded like linux does for unprivileged users
+func (r random) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+var Dev = devfs.New()
+
+func devInit() {
+	Dev.Register("zero", devfs.Device{R: zero{}, W: null{}})
+	Dev.Register("null", devfs.Device{R: null{}, W: null{}})
+	Dev.Register("random", devfs.Device{R: random{}, W: random{}})
+	Dev.Register("urandom", devfs.Device{R: random{}, W: random{}})
+	err := Mount("/dev", Dev)
+	if err != nil {
+		panic(err)
+	}
 }
@@ -0,0 +1,261 @@
+// devfs serves character devices registered by name, it's a flat
+// read-only namespace, only the device files themselves can be written.
+package devfs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// assert that devfs.Fs implements afero.Fs.
+var _ afero.Fs = (*Fs)(nil)
+
+// Device is a character device, Read or Write returns EINVAL if it's nil.
+type Device struct {
+	R io.Reader
+	W io.Writer
+}
+
+type Fs struct {
+	mutex   sync.Mutex
+	devices map[string]Device
+	now     time.Time
+}
+
+func New() *Fs {
+	return &Fs{
+		devices: make(map[string]Device),
+		now:     time.Now(),
+	}
+}
+
+// Register adds the device with name at the root of fs.
+func (f *Fs) Register(name string, dev Device) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.devices[name] = dev
+}
+
+func (f *Fs) lookup(name string) (string, Device, bool, error) {
+	name = strings.TrimPrefix(filepath.Clean("/"+name), "/")
+	if name == "" {
+		return "", Device{}, true, nil
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	dev, ok := f.devices[name]
+	if !ok {
+		return "", Device{}, false, os.ErrNotExist
+	}
+	return name, dev, false, nil
+}
+
+func (f *Fs) Name() string { return "devfs" }
+
+func (f *Fs) Create(name string) (afero.File, error) {
+	return f.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (f *Fs) Open(name string) (afero.File, error) {
+	return f.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (f *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	devname, dev, isdir, err := f.lookup(name)
+	if err != nil {
+		if flag&os.O_CREATE != 0 {
+			err = syscall.EROFS
+		}
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	if isdir {
+		return &dir{fs: f}, nil
+	}
+	return &file{name: devname, dev: dev, modTime: f.now}, nil
+}
+
+func (f *Fs) Stat(name string) (os.FileInfo, error) {
+	devname, _, isdir, err := f.lookup(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+	if isdir {
+		return &fileInfo{name: "/", mode: os.ModeDir | 0755, modTime: f.now}, nil
+	}
+	return newDevInfo(devname, f.now), nil
+}
+
+func (f *Fs) Mkdir(name string, perm os.FileMode) error {
+	return &os.PathError{Op: "mkdir", Path: name, Err: syscall.EROFS}
+}
+
+func (f *Fs) MkdirAll(path string, perm os.FileMode) error {
+	return f.Mkdir(path, perm)
+}
+
+func (f *Fs) Remove(name string) error {
+	return &os.PathError{Op: "remove", Path: name, Err: syscall.EROFS}
+}
+
+func (f *Fs) RemoveAll(path string) error {
+	return f.Remove(path)
+}
+
+func (f *Fs) Rename(oldname, newname string) error {
+	return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EROFS}
+}
+
+func (f *Fs) Chmod(name string, mode os.FileMode) error {
+	return &os.PathError{Op: "chmod", Path: name, Err: syscall.EROFS}
+}
+
+func (f *Fs) Chown(name string, uid, gid int) error {
+	return &os.PathError{Op: "chown", Path: name, Err: syscall.EROFS}
+}
+
+func (f *Fs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return &os.PathError{Op: "chtimes", Path: name, Err: syscall.EROFS}
+}
+
+type fileInfo struct {
+	name    string
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func newDevInfo(name string, modTime time.Time) *fileInfo {
+	return &fileInfo{
+		name:    name,
+		mode:    os.ModeDevice | os.ModeCharDevice | 0666,
+		modTime: modTime,
+	}
+}
+
+func (i *fileInfo) Name() string       { return i.name }
+func (i *fileInfo) Size() int64        { return 0 }
+func (i *fileInfo) Mode() os.FileMode  { return i.mode }
+func (i *fileInfo) ModTime() time.Time { return i.modTime }
+func (i *fileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *fileInfo) Sys() interface{}   { return nil }
+
+// file is an opened device, seeking is a no-op like on linux.
+type file struct {
+	name    string
+	dev     Device
+	modTime time.Time
+}
+
+func (d *file) Name() string { return d.name }
+
+func (d *file) Read(p []byte) (int, error) {
+	if d.dev.R == nil {
+		return 0, syscall.EINVAL
+	}
+	return d.dev.R.Read(p)
+}
+
+func (d *file) ReadAt(p []byte, off int64) (int, error) {
+	return d.Read(p)
+}
+
+func (d *file) Write(p []byte) (int, error) {
+	if d.dev.W == nil {
+		return 0, syscall.EINVAL
+	}
+	return d.dev.W.Write(p)
+}
+
+func (d *file) WriteAt(p []byte, off int64) (int, error) {
+	return d.Write(p)
+}
+
+func (d *file) WriteString(s string) (int, error) {
+	return d.Write([]byte(s))
+}
+
+func (d *file) Seek(offset int64, whence int) (int64, error) {
+	return 0, nil
+}
+
+func (d *file) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, syscall.ENOTDIR
+}
+
+func (d *file) Readdirnames(n int) ([]string, error) {
+	return nil, syscall.ENOTDIR
+}
+
+func (d *file) Stat() (os.FileInfo, error) {
+	return newDevInfo(d.name, d.modTime), nil
+}
+
+func (d *file) Sync() error               { return nil }
+func (d *file) Truncate(size int64) error { return syscall.EINVAL }
+func (d *file) Close() error              { return nil }
+
+// dir is the opened root directory.
+type dir struct {
+	fs  *Fs
+	off int
+}
+
+func (d *dir) names() []string {
+	d.fs.mutex.Lock()
+	defer d.fs.mutex.Unlock()
+	names := make([]string, 0, len(d.fs.devices))
+	for name := range d.fs.devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (d *dir) Name() string { return "/" }
+
+func (d *dir) Readdirnames(n int) ([]string, error) {
+	names := d.names()
+	if d.off >= len(names) {
+		names = nil
+	} else {
+		names = names[d.off:]
+	}
+	if n > 0 && len(names) > n {
+		names = names[:n]
+	}
+	d.off += len(names)
+	if n > 0 && len(names) == 0 {
+		return nil, io.EOF
+	}
+	return names, nil
+}
+
+func (d *dir) Readdir(count int) ([]os.FileInfo, error) {
+	names, err := d.Readdirnames(count)
+	infos := make([]os.FileInfo, 0, len(names))
+	for _, name := range names {
+		infos = append(infos, newDevInfo(name, d.fs.now))
+	}
+	return infos, err
+}
+
+func (d *dir) Stat() (os.FileInfo, error) {
+	return &fileInfo{name: "/", mode: os.ModeDir | 0755, modTime: d.fs.now}, nil
+}
+
+func (d *dir) Read(p []byte) (int, error)                   { return 0, syscall.EISDIR }
+func (d *dir) ReadAt(p []byte, off int64) (int, error)      { return 0, syscall.EISDIR }
+func (d *dir) Write(p []byte) (int, error)                  { return 0, syscall.EISDIR }
+func (d *dir) WriteAt(p []byte, off int64) (int, error)     { return 0, syscall.EISDIR }
+func (d *dir) WriteString(s string) (int, error)            { return 0, syscall.EISDIR }
+func (d *dir) Seek(offset int64, whence int) (int64, error) { d.off = 0; return 0, nil }
+func (d *dir) Sync() error                                  { return nil }
+func (d *dir) Truncate(size int64) error                    { return syscall.EISDIR }
+func (d *dir) Close() error                                 { return nil }
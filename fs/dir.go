@@ -0,0 +1,422 @@
+package fs
+
+import (
+	"errors"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/banditmoscow1337/spos/fs/mount"
+	"github.com/banditmoscow1337/spos/kernel/isyscall"
+	"github.com/banditmoscow1337/spos/kernel/sys"
+
+	"github.com/spf13/afero"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// the size of the fixed part of linux_dirent64
+	direntHeader = 19
+)
+
+var (
+	cwdLock sync.Mutex
+	// the working directory of the syscalls, there is a single process
+	cwd = "/"
+)
+
+// errno maps the errors of afero and the mounts to the errno of linux
+func errno(err error) syscall.Errno {
+	var code syscall.Errno
+	switch {
+	case errors.As(err, &code):
+		return code
+	case errors.Is(err, os.ErrNotExist):
+		return syscall.ENOENT
+	case errors.Is(err, os.ErrExist):
+		return syscall.EEXIST
+	case errors.Is(err, os.ErrPermission):
+		return syscall.EPERM
+	case errors.Is(err, afero.ErrFileClosed):
+		return syscall.EBADF
+	case errors.Is(err, afero.ErrTooLarge):
+		return syscall.EFBIG
+	case mount.IsErrCrossFsRename(err):
+		return syscall.EXDEV
+	case mount.IsErrNotAFile(err):
+		return syscall.EISDIR
+	}
+	return syscall.EINVAL
+}
+
+// resolve returns the absolute path of name relative to the directory dirfd
+func resolve(dirfd int, name string) (string, error) {
+	if name == "" {
+		return "", syscall.ENOENT
+	}
+	if filepath.IsAbs(name) {
+		return filepath.Clean(name), nil
+	}
+	if dirfd == unix.AT_FDCWD {
+		cwdLock.Lock()
+		defer cwdLock.Unlock()
+		return filepath.Join(cwd, name), nil
+	}
+	ni, err := GetInode(dirfd)
+	if err != nil {
+		return "", err
+	}
+	if ni.path == "" {
+		return "", syscall.ENOTDIR
+	}
+	if err := isDir(ni.path); err != nil {
+		return "", err
+	}
+	return filepath.Join(ni.path, name), nil
+}
+
+// isDir returns ENOENT or ENOTDIR unless path is a directory
+func isDir(path string) error {
+	info, err := Root.Stat(path)
+	if err != nil {
+		return errno(err)
+	}
+	if !info.IsDir() {
+		return syscall.ENOTDIR
+	}
+	return nil
+}
+
+// inodeNumber makes up a stable inode number from the path, afero has none
+func inodeNumber(path string) uint64 {
+	h := fnv.New64a()
+	io.WriteString(h, path)
+	if ino := h.Sum64(); ino != 0 {
+		return ino
+	}
+	return 1
+}
+
+func direntType(mode os.FileMode) uint8 {
+	switch {
+	case mode.IsDir():
+		return unix.DT_DIR
+	case mode&os.ModeSymlink != 0:
+		return unix.DT_LNK
+	case mode&os.ModeNamedPipe != 0:
+		return unix.DT_FIFO
+	case mode&os.ModeSocket != 0:
+		return unix.DT_SOCK
+	case mode&os.ModeCharDevice != 0:
+		return unix.DT_CHR
+	case mode&os.ModeDevice != 0:
+		return unix.DT_BLK
+	}
+	return unix.DT_REG
+}
+
+// readdir loads the entries of the directory on the first call, the mounts
+// add their entries on every Readdir so they are all read at once.
+func readdir(ni *Inode) error {
+	if ni.dirents != nil {
+		return nil
+	}
+	f, ok := ni.File.(afero.File)
+	if !ok || ni.path == "" {
+		return syscall.ENOTDIR
+	}
+	dot, err := f.Stat()
+	if err != nil {
+		return errno(err)
+	}
+	if !dot.IsDir() {
+		return syscall.ENOTDIR
+	}
+	infos, err := f.Readdir(-1)
+	if err != nil {
+		return errno(err)
+	}
+	ni.dirents = append([]os.FileInfo{dot, dot}, infos...)
+	return nil
+}
+
+// func getdents64(fd int, dirp *byte, count int)
+func sysGetdents64(ni *Inode, p, n uintptr) (int, error) {
+	ni.mutex.Lock()
+	defer ni.mutex.Unlock()
+	err := readdir(ni)
+	if err != nil {
+		return 0, err
+	}
+	buf := sys.UnsafeBuffer(p, int(n))
+	off := 0
+	for ; ni.dirpos < len(ni.dirents); ni.dirpos++ {
+		info := ni.dirents[ni.dirpos]
+		name := info.Name()
+		path := filepath.Join(ni.path, name)
+		switch ni.dirpos {
+		case 0:
+			name, path = ".", ni.path
+		case 1:
+			name, path = "..", filepath.Dir(ni.path)
+		}
+		reclen := (direntHeader + len(name) + 1 + 7) &^ 7
+		if off+reclen > len(buf) {
+			if off == 0 {
+				return 0, syscall.EINVAL
+			}
+			break
+		}
+		rec := buf[off : off+reclen]
+		*(*uint64)(unsafe.Pointer(&rec[0])) = inodeNumber(path)
+		*(*int64)(unsafe.Pointer(&rec[8])) = int64(ni.dirpos + 1)
+		*(*uint16)(unsafe.Pointer(&rec[16])) = uint16(reclen)
+		rec[18] = direntType(info.Mode())
+		copy(rec[direntHeader:], name)
+		for i := direntHeader + len(name); i < reclen; i++ {
+			rec[i] = 0
+		}
+		off += reclen
+	}
+	return off, nil
+}
+
+// func mkdirat(dirfd int, path string, mode uint32)
+func sysMkdirat(c *isyscall.Request) {
+	path, err := resolve(int(c.Arg(0)), cstring(c.Arg(1)))
+	if err != nil {
+		c.SetError(err)
+		return
+	}
+	if _, err := Root.Stat(path); err == nil {
+		c.SetErrorNO(syscall.EEXIST)
+		return
+	}
+	// afero makes the missing parents
+	if err := isDir(filepath.Dir(path)); err != nil {
+		c.SetError(err)
+		return
+	}
+	err = Root.Mkdir(path, os.FileMode(c.Arg(2))&os.ModePerm)
+	if err != nil {
+		c.SetErrorNO(errno(err))
+		return
+	}
+	c.SetRet(0)
+}
+
+// isEmptyDir reports whether the directory has no entries, the mount points count
+func isEmptyDir(path string) (bool, error) {
+	f, err := Root.Open(path)
+	if err != nil {
+		return false, errno(err)
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return false, errno(err)
+	}
+	return len(names) == 0, nil
+}
+
+// func unlinkat(dirfd int, path string, flags int)
+func sysUnlinkat(c *isyscall.Request) {
+	path, err := resolve(int(c.Arg(0)), cstring(c.Arg(1)))
+	if err != nil {
+		c.SetError(err)
+		return
+	}
+	flags := c.Arg(2)
+	if flags&^unix.AT_REMOVEDIR != 0 {
+		c.SetErrorNO(syscall.EINVAL)
+		return
+	}
+	info, err := Root.Stat(path)
+	if err != nil {
+		c.SetErrorNO(errno(err))
+		return
+	}
+	switch {
+	case flags&unix.AT_REMOVEDIR == 0 && info.IsDir():
+		c.SetErrorNO(syscall.EISDIR)
+		return
+	case flags&unix.AT_REMOVEDIR != 0 && !info.IsDir():
+		c.SetErrorNO(syscall.ENOTDIR)
+		return
+	case mount.IsMountNode(info) || path == "/":
+		c.SetErrorNO(syscall.EBUSY)
+		return
+	}
+	if info.IsDir() {
+		empty, err := isEmptyDir(path)
+		if err != nil {
+			c.SetError(err)
+			return
+		}
+		if !empty {
+			c.SetErrorNO(syscall.ENOTEMPTY)
+			return
+		}
+	}
+	err = Root.Remove(path)
+	if err != nil {
+		c.SetErrorNO(errno(err))
+		return
+	}
+	c.SetRet(0)
+}
+
+// rename checks what afero doesn't, a directory replaces only an empty directory
+// and a file replaces only a file.
+func rename(oldpath, newpath string, noreplace bool) error {
+	oinfo, err := Root.Stat(oldpath)
+	if err != nil {
+		return errno(err)
+	}
+	if mount.IsMountNode(oinfo) || oldpath == "/" {
+		return syscall.EBUSY
+	}
+	if oldpath == newpath {
+		return nil
+	}
+	if oinfo.IsDir() && strings.HasPrefix(newpath, oldpath+"/") {
+		return syscall.EINVAL
+	}
+	if err := isDir(filepath.Dir(newpath)); err != nil {
+		return err
+	}
+	ninfo, err := Root.Stat(newpath)
+	if err == nil {
+		switch {
+		case noreplace:
+			return syscall.EEXIST
+		case mount.IsMountNode(ninfo):
+			return syscall.EBUSY
+		case oinfo.IsDir() && !ninfo.IsDir():
+			return syscall.ENOTDIR
+		case !oinfo.IsDir() && ninfo.IsDir():
+			return syscall.EISDIR
+		}
+		if ninfo.IsDir() {
+			empty, err := isEmptyDir(newpath)
+			if err != nil {
+				return err
+			}
+			if !empty {
+				return syscall.ENOTEMPTY
+			}
+		}
+		if err := Root.Remove(newpath); err != nil {
+			return errno(err)
+		}
+	}
+	if oinfo.IsDir() {
+		return renameDir(oldpath, newpath)
+	}
+	if err := Root.Rename(oldpath, newpath); err != nil {
+		return errno(err)
+	}
+	return nil
+}
+
+// renameDir moves the entries first, afero renames only the directory itself
+func renameDir(oldpath, newpath string) error {
+	f, err := Root.Open(oldpath)
+	if err != nil {
+		return errno(err)
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return errno(err)
+	}
+	err = Root.Mkdir(newpath, os.ModePerm)
+	if err != nil {
+		return errno(err)
+	}
+	for _, name := range names {
+		err = rename(filepath.Join(oldpath, name), filepath.Join(newpath, name), false)
+		if err != nil {
+			return err
+		}
+	}
+	if err := Root.Remove(oldpath); err != nil {
+		return errno(err)
+	}
+	return nil
+}
+
+// func renameat2(olddirfd int, oldpath string, newdirfd int, newpath string, flags uint)
+func sysRenameat(c *isyscall.Request) {
+	var flags uintptr
+	if c.NO() == unix.SYS_RENAMEAT2 {
+		flags = c.Arg(4)
+	}
+	if flags&^unix.RENAME_NOREPLACE != 0 {
+		c.SetErrorNO(syscall.EINVAL)
+		return
+	}
+	oldpath, err := resolve(int(c.Arg(0)), cstring(c.Arg(1)))
+	if err != nil {
+		c.SetError(err)
+		return
+	}
+	newpath, err := resolve(int(c.Arg(2)), cstring(c.Arg(3)))
+	if err != nil {
+		c.SetError(err)
+		return
+	}
+	c.SetError(rename(oldpath, newpath, flags&unix.RENAME_NOREPLACE != 0))
+}
+
+func chdir(path string) error {
+	if err := isDir(path); err != nil {
+		return err
+	}
+	cwdLock.Lock()
+	cwd = path
+	cwdLock.Unlock()
+	return nil
+}
+
+func sysChdir(c *isyscall.Request) {
+	path, err := resolve(unix.AT_FDCWD, cstring(c.Arg(0)))
+	if err != nil {
+		c.SetError(err)
+		return
+	}
+	c.SetError(chdir(path))
+}
+
+func sysFchdir(c *isyscall.Request) {
+	ni, err := GetInode(int(c.Arg(0)))
+	if err != nil {
+		c.SetError(err)
+		return
+	}
+	if ni.path == "" {
+		c.SetErrorNO(syscall.ENOTDIR)
+		return
+	}
+	c.SetError(chdir(ni.path))
+}
+
+// func getcwd(buf *byte, size int)
+func sysGetcwd(c *isyscall.Request) {
+	cwdLock.Lock()
+	dir := cwd
+	cwdLock.Unlock()
+	if uintptr(len(dir)+1) > c.Arg(1) {
+		c.SetErrorNO(syscall.ERANGE)
+		return
+	}
+	buf := sys.UnsafeBuffer(c.Arg(0), len(dir)+1)
+	copy(buf, dir)
+	buf[len(dir)] = 0
+	c.SetRet(uintptr(len(dir) + 1))
+}
@@ -1,14 +1,34 @@
 package fs
 
-import "github.com/spf13/afero"
+import (
+	"strings"
+
+	"github.com/banditmoscow1337/spos/bootcfg"
+	"github.com/spf13/afero"
+)
 
 var builtinFiles = map[string]string{
-	"/etc/resolv.conf":          `nameserver 114.114.114.114`,
-	"/proc/sys/kernel/hostname": `spos`,
+	"/etc/resolv.conf": `nameserver 114.114.114.114`,
+}
+
+// resolvConf returns the name servers of spos_DNS, or the default ones
+func resolvConf() string {
+	dns := bootcfg.Get().Network.DNS
+	if len(dns) == 0 {
+		return builtinFiles["/etc/resolv.conf"]
+	}
+	var b strings.Builder
+	for _, ip := range dns {
+		b.WriteString("nameserver " + ip.String() + "\n")
+	}
+	return b.String()
 }
 
 func etcInit() {
 	for name, content := range builtinFiles {
+		if name == "/etc/resolv.conf" {
+			content = resolvConf()
+		}
 		err := afero.WriteFile(Root, name, []byte(content), 0644)
 		if err != nil {
 			panic(err)
@@ -0,0 +1,143 @@
+package fs
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/banditmoscow1337/spos/kernel/isyscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// dupFd makes a new fd not less than min sharing the inode of fd
+func dupFd(fd, min int, cloexec bool) (int, error) {
+	if min < 0 || min >= _MAX_FDS {
+		return -1, syscall.EINVAL
+	}
+	inodeLock.Lock()
+	defer inodeLock.Unlock()
+	if fd >= len(fdtable) || fd < 0 || fdtable[fd].ni == nil {
+		return -1, syscall.EBADF
+	}
+	return installFd(fdtable[fd].ni, min, cloexec)
+}
+
+// dupTo makes newfd share the inode of fd, the file at newfd is closed silently
+func dupTo(fd, newfd int, cloexec bool) error {
+	inodeLock.Lock()
+	if fd >= len(fdtable) || fd < 0 || fdtable[fd].ni == nil ||
+		newfd < 0 || newfd >= _MAX_FDS || reservedFd(newfd) {
+		inodeLock.Unlock()
+		return syscall.EBADF
+	}
+	ni := fdtable[fd].ni
+	if fd == newfd {
+		inodeLock.Unlock()
+		return nil
+	}
+	for newfd >= len(fdtable) {
+		fdtable = append(fdtable, fdEntry{})
+	}
+	old := fdtable[newfd].ni
+	fdtable[newfd] = fdEntry{ni: ni, cloexec: cloexec}
+	ni.refs++
+	var last bool
+	if old != nil {
+		old.refs--
+		last = old.refs == 0
+	}
+	inodeLock.Unlock()
+
+	if last && old.File != nil {
+		old.File.Close()
+	}
+	return nil
+}
+
+// func dup3(oldfd int, newfd int, flags int)
+func sysDup(c *isyscall.Request) {
+	fd := int(c.Arg(0))
+	switch c.NO() {
+	case syscall.SYS_DUP:
+		newfd, err := dupFd(fd, 0, false)
+		if err != nil {
+			c.SetErrorNO(errno(err))
+			return
+		}
+		c.SetRet(uintptr(newfd))
+		return
+	case syscall.SYS_DUP3:
+		flags := c.Arg(2)
+		if flags&^syscall.O_CLOEXEC != 0 || int(c.Arg(1)) == fd {
+			c.SetErrorNO(syscall.EINVAL)
+			return
+		}
+		err := dupTo(fd, int(c.Arg(1)), flags&syscall.O_CLOEXEC != 0)
+		if err != nil {
+			c.SetErrorNO(errno(err))
+			return
+		}
+	default:
+		err := dupTo(fd, int(c.Arg(1)), false)
+		if err != nil {
+			c.SetErrorNO(errno(err))
+			return
+		}
+	}
+	c.SetRet(c.Arg(1))
+}
+
+func getCloexec(fd int) bool {
+	inodeLock.Lock()
+	defer inodeLock.Unlock()
+	return fdtable[fd].cloexec
+}
+
+func setCloexec(fd int, cloexec bool) {
+	inodeLock.Lock()
+	defer inodeLock.Unlock()
+	fdtable[fd].cloexec = cloexec
+}
+
+// func fcntl(fd int, cmd int, arg int)
+func sysFcntl(c *isyscall.Request) {
+	fd, cmd, arg := int(c.Arg(0)), c.Arg(1), c.Arg(2)
+	ni, err := GetInode(fd)
+	if err != nil {
+		c.SetErrorNO(errno(err))
+		return
+	}
+	switch cmd {
+	case syscall.F_DUPFD, syscall.F_DUPFD_CLOEXEC:
+		newfd, err := dupFd(fd, int(arg), cmd == syscall.F_DUPFD_CLOEXEC)
+		if err != nil {
+			c.SetErrorNO(errno(err))
+			return
+		}
+		c.SetRet(uintptr(newfd))
+	case syscall.F_GETFD:
+		var flags uintptr
+		if getCloexec(fd) {
+			flags = syscall.FD_CLOEXEC
+		}
+		c.SetRet(flags)
+	case syscall.F_SETFD:
+		setCloexec(fd, arg&syscall.FD_CLOEXEC != 0)
+		c.SetRet(0)
+	case syscall.F_GETFL:
+		c.SetRet(uintptr(ni.Flags()))
+	case syscall.F_SETFL:
+		flags := ni.Flags()&^_STATUS_FLAGS | int(arg)&_STATUS_FLAGS
+		ni.setFlags(flags)
+		c.SetRet(0)
+	case syscall.F_GETLK, unix.F_OFD_GETLK:
+		// there is a single process, every lock can be taken
+		lk := (*syscall.Flock_t)(unsafe.Pointer(arg))
+		lk.Type = syscall.F_UNLCK
+		c.SetRet(0)
+	case syscall.F_SETLK, syscall.F_SETLKW, unix.F_OFD_SETLK, unix.F_OFD_SETLKW:
+		c.SetRet(0)
+	default:
+		c.SetErrorNO(syscall.EINVAL)
+	}
+}
@@ -0,0 +1,169 @@
+// initramfs unpacks the boot modules in tar or cpio (newc) format,
+// the formats are detected by the magic of the first header.
+package initramfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+const (
+	tarMagicOffset = 257
+	tarMagic       = "ustar"
+
+	cpioHeaderSize = 110
+	cpioMagic      = "070701"
+	// the checksum variant has the same layout
+	cpioMagicCRC = "070702"
+	cpioTrailer  = "TRAILER!!!"
+
+	// file types of the cpio mode
+	cpioTypeMask = 0170000
+	cpioTypeDir  = 0040000
+	cpioTypeReg  = 0100000
+)
+
+var ErrFormat = errors.New("unknown archive format")
+
+// IsArchive reports whether data starts with a tar or cpio header
+func IsArchive(data []byte) bool {
+	return isTar(data) || isCpio(data)
+}
+
+func isTar(data []byte) bool {
+	return len(data) > tarMagicOffset+len(tarMagic) &&
+		string(data[tarMagicOffset:tarMagicOffset+len(tarMagic)]) == tarMagic
+}
+
+func isCpio(data []byte) bool {
+	if len(data) < cpioHeaderSize {
+		return false
+	}
+	magic := string(data[:len(cpioMagic)])
+	return magic == cpioMagic || magic == cpioMagicCRC
+}
+
+// Unpack extracts the archive in data to the root of dst, only the
+// directories and the regular files are extracted, the others are skipped.
+func Unpack(dst afero.Fs, data []byte) error {
+	switch {
+	case isTar(data):
+		return unpackTar(dst, bytes.NewReader(data))
+	case isCpio(data):
+		return unpackCpio(dst, data)
+	default:
+		return ErrFormat
+	}
+}
+
+// clean makes name relative to the root of the archive, ".." can't escape it
+func clean(name string) string {
+	return path.Clean("/" + name)
+}
+
+func writeFile(dst afero.Fs, name string, r io.Reader, mode os.FileMode, mtime time.Time) error {
+	err := dst.MkdirAll(path.Dir(name), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := dst.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	return dst.Chtimes(name, mtime, mtime)
+}
+
+func unpackTar(dst afero.Fs, r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := clean(hdr.Name)
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = dst.MkdirAll(name, mode)
+		case tar.TypeReg:
+			err = writeFile(dst, name, tr, mode, hdr.ModTime)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// cpioField parses the i-th 8 digits hex field of the header
+func cpioField(hdr []byte, i int) (int64, error) {
+	off := len(cpioMagic) + i*8
+	return strconv.ParseInt(string(hdr[off:off+8]), 16, 64)
+}
+
+func align4(n int64) int64 {
+	return (n + 3) &^ 3
+}
+
+func unpackCpio(dst afero.Fs, data []byte) error {
+	var off int64
+	size := int64(len(data))
+	for {
+		if off+cpioHeaderSize > size || !isCpio(data[off:]) {
+			return fmt.Errorf("bad cpio header at %d", off)
+		}
+		hdr := data[off : off+cpioHeaderSize]
+		// fields: ino mode uid gid nlink mtime filesize devmajor devminor rdevmajor rdevminor namesize check
+		var fields [13]int64
+		for i := range fields {
+			v, err := cpioField(hdr, i)
+			if err != nil {
+				return fmt.Errorf("bad cpio header at %d: %w", off, err)
+			}
+			fields[i] = v
+		}
+		mode, mtime, filesize, namesize := fields[1], fields[5], fields[6], fields[11]
+
+		nameStart := off + cpioHeaderSize
+		dataStart := align4(nameStart + namesize)
+		dataEnd := dataStart + filesize
+		if namesize < 1 || dataEnd > size {
+			return fmt.Errorf("truncated cpio entry at %d", off)
+		}
+		// the name is nul terminated
+		name := string(data[nameStart : nameStart+namesize-1])
+		if name == cpioTrailer {
+			return nil
+		}
+
+		var err error
+		perm := os.FileMode(mode).Perm()
+		switch mode & cpioTypeMask {
+		case cpioTypeDir:
+			err = dst.MkdirAll(clean(name), perm)
+		case cpioTypeReg:
+			body := bytes.NewReader(data[dataStart:dataEnd])
+			err = writeFile(dst, clean(name), body, perm, time.Unix(mtime, 0))
+		}
+		if err != nil {
+			return err
+		}
+		off = align4(dataEnd)
+	}
+}
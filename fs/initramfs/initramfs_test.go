@@ -0,0 +1,100 @@
+package initramfs_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/banditmoscow1337/spos/fs/initramfs"
+	"github.com/spf13/afero"
+)
+
+var files = []struct {
+	name, body string
+}{
+	{"etc/motd", "hello\n"},
+	{"www/index.html", "<html></html>"},
+	{"../escape", "x"},
+}
+
+func makeTar(t *testing.T) []byte {
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	w.WriteHeader(&tar.Header{Name: "www/", Typeflag: tar.TypeDir, Mode: 0755})
+	for _, f := range files {
+		w.WriteHeader(&tar.Header{Name: f.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(f.body))})
+		w.Write([]byte(f.body))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func cpioEntry(buf *bytes.Buffer, name string, mode int, body string) {
+	fmt.Fprintf(buf, "070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+		0, mode, 0, 0, 1, 0, len(body), 0, 0, 0, 0, len(name)+1, 0)
+	buf.WriteString(name + "\x00")
+	for buf.Len()%4 != 0 {
+		buf.WriteByte(0)
+	}
+	buf.WriteString(body)
+	for buf.Len()%4 != 0 {
+		buf.WriteByte(0)
+	}
+}
+
+func makeCpio() []byte {
+	var buf bytes.Buffer
+	cpioEntry(&buf, "www", 040755, "")
+	for _, f := range files {
+		cpioEntry(&buf, f.name, 0100644, f.body)
+	}
+	cpioEntry(&buf, "TRAILER!!!", 0, "")
+	return buf.Bytes()
+}
+
+func TestUnpack(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		data []byte
+	}{
+		{"tar", makeTar(t)},
+		{"cpio", makeCpio()},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if !initramfs.IsArchive(test.data) {
+				t.Fatal("archive not detected")
+			}
+			fs := afero.NewMemMapFs()
+			if err := initramfs.Unpack(fs, test.data); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if isdir, _ := afero.IsDir(fs, "/www"); !isdir {
+				t.Error("/www is not a directory")
+			}
+			for _, want := range []struct{ name, body string }{
+				{"/etc/motd", "hello\n"},
+				{"/www/index.html", "<html></html>"},
+				{"/escape", "x"},
+			} {
+				body, err := afero.ReadFile(fs, want.name)
+				if err != nil || string(body) != want.body {
+					t.Errorf("%s: got %q, %v", want.name, body, err)
+				}
+			}
+		})
+	}
+}
+
+func TestUnpack_Errors(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	if err := initramfs.Unpack(fs, []byte("\x7fELF")); err != initramfs.ErrFormat {
+		t.Errorf("expected ErrFormat, got %v", err)
+	}
+	data := makeCpio()
+	if err := initramfs.Unpack(fs, data[:len(data)-200]); err == nil {
+		t.Error("expected error for a truncated cpio, received none")
+	}
+}
@@ -0,0 +1,45 @@
+package fs
+
+import (
+	"github.com/banditmoscow1337/spos/bootcfg"
+	"github.com/banditmoscow1337/spos/drivers/multiboot"
+	"github.com/banditmoscow1337/spos/fs/initramfs"
+	"github.com/banditmoscow1337/spos/kernel/sys"
+	"github.com/banditmoscow1337/spos/log"
+
+	"github.com/spf13/afero"
+)
+
+// initrdInit unpacks the boot modules in tar or cpio format, the first
+// module is the kernel image. With spos_INITRD=/ they're unpacked into
+// the root, or else into one read-only fs mounted at the path.
+func initrdInit() {
+	mods := multiboot.Modules()
+	if len(mods) <= 1 {
+		return
+	}
+	target := bootcfg.Get().InitrdPath
+	var dst afero.Fs = Root
+	if target != "/" {
+		dst = afero.NewMemMapFs()
+	}
+	n := 0
+	for i, m := range mods[1:] {
+		data := sys.UnsafeBuffer(m.Start, int(m.End-m.Start))
+		if !initramfs.IsArchive(data) {
+			log.Infof("[initrd] module %d isn't a tar or cpio archive, skipped", i+1)
+			continue
+		}
+		if err := initramfs.Unpack(dst, data); err != nil {
+			log.Errorf("[initrd] module %d: %s", i+1, err)
+			continue
+		}
+		n++
+	}
+	if n == 0 || target == "/" {
+		return
+	}
+	if err := Mount(target, afero.NewReadOnlyFs(dst)); err != nil {
+		log.Errorf("[initrd] mount %s: %s", target, err)
+	}
+}
@@ -25,8 +25,11 @@ import (
 	. "github.com/spf13/afero"
 )
 
-// assert that mount.MountableFs implements afero.Fs.
-var _ Fs = (*MountableFs)(nil)
+// assert that mount.MountableFs implements afero.Fs and afero.Lstater.
+var (
+	_ Fs      = (*MountableFs)(nil)
+	_ Lstater = (*MountableFs)(nil)
+)
 
 // MountableFs allows different paths in a hierarchy to be served by different
 // afero.Fs objects.
@@ -296,6 +299,35 @@ func (m *MountableFs) Rename(oldname string, newname string) error {
 	}
 }
 
+// MountPoint returns the path the Fs serving name is mounted at
+func (m *MountableFs) MountPoint(name string) string {
+	_, base, _ := m.node.findPath(name)
+	return base
+}
+
+// MountInfo is a Fs mounted at Path
+type MountInfo struct {
+	Path string
+	Fs   Fs
+}
+
+// Mounts returns the mounted Fs sorted by path, the base Fs is mounted at /
+func (m *MountableFs) Mounts() []MountInfo {
+	var out []MountInfo
+	var walk func(n *mountableNode)
+	walk = func(n *mountableNode) {
+		if n.fs != nil {
+			out = append(out, MountInfo{Path: n.fullName(), Fs: n.fs})
+		}
+		for _, child := range n.nodes {
+			walk(child)
+		}
+	}
+	walk(m.node)
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out
+}
+
 func (m *MountableFs) Stat(name string) (os.FileInfo, error) {
 	node := m.node.findNode(name)
 	if node != nil && node != m.node {
@@ -309,6 +341,29 @@ func (m *MountableFs) Stat(name string) (os.FileInfo, error) {
 	return info, nil
 }
 
+// LstatIfPossible calls Lstat of the Fs serving name if it has one,
+// the bool reports whether it was called.
+func (m *MountableFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	node := m.node.findNode(name)
+	if node != nil && node != m.node {
+		info, err := mountedDirFromNode(node)
+		return info, false, err
+	}
+	fs, _, rel := m.node.findPath(name)
+	if lfs, ok := fs.(Lstater); ok {
+		info, called, err := lfs.LstatIfPossible(rel)
+		if err != nil {
+			return nil, called, wrapErrorPath(name, err)
+		}
+		return info, called, nil
+	}
+	info, err := fs.Stat(rel)
+	if err != nil {
+		return nil, false, wrapErrorPath(name, err)
+	}
+	return info, false, nil
+}
+
 func (m *MountableFs) Name() string {
 	return "MountableFs"
 }
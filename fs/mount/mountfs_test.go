@@ -0,0 +1,46 @@
+package mount_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/banditmoscow1337/spos/fs/mount"
+	"github.com/spf13/afero"
+)
+
+func TestLstatIfPossible(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("file", filepath.Join(dir, "link")); err != nil {
+		t.Skip(err)
+	}
+
+	m := mount.NewMountableFs(nil)
+	if err := m.Mount("/host", afero.NewBasePathFs(afero.NewOsFs(), dir)); err != nil {
+		t.Fatal(err)
+	}
+
+	info, called, err := m.LstatIfPossible("/host/link")
+	if err != nil || !called {
+		t.Fatalf("lstat link: %v %v", called, err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("lstat link mode %v", info.Mode())
+	}
+	info, err = m.Stat("/host/link")
+	if err != nil || !info.Mode().IsRegular() {
+		t.Fatalf("stat link: %v %v", info, err)
+	}
+
+	// the mount point itself and the base Fs without Lstat
+	info, called, err = m.LstatIfPossible("/host")
+	if err != nil || called || !info.IsDir() {
+		t.Fatalf("lstat mount point: %v %v", called, err)
+	}
+	if _, called, err = m.LstatIfPossible("/missing"); called || !os.IsNotExist(err) {
+		t.Fatalf("lstat missing: %v %v", called, err)
+	}
+}
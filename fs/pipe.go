@@ -0,0 +1,40 @@
+package fs
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/banditmoscow1337/spos/fs/pipe"
+	"github.com/banditmoscow1337/spos/kernel/isyscall"
+)
+
+// func pipe2(p *[2]int32, flags int)
+//
+// the pipe of the runtime netpoller is created by the kernel, see kernel/pipe.go
+func sysPipe2(c *isyscall.Request) {
+	flags := c.Arg(1)
+	if flags&^(syscall.O_NONBLOCK|syscall.O_CLOEXEC) != 0 {
+		c.SetErrorNO(syscall.EINVAL)
+		return
+	}
+	r, w := pipe.New()
+	rfd, rni, err := allocFile(r, syscall.O_RDONLY|int(flags))
+	if err != nil {
+		c.SetErrorNO(errno(err))
+		return
+	}
+	wfd, wni, err := allocFile(w, syscall.O_WRONLY|int(flags))
+	if err != nil {
+		closeFd(rfd)
+		c.SetErrorNO(errno(err))
+		return
+	}
+	// the events follow the fds dup'ed from the ends
+	r.Notify = rni.Notify
+	w.Notify = wni.Notify
+
+	fds := (*[2]int32)(unsafe.Pointer(c.Arg(0)))
+	fds[0] = int32(rfd)
+	fds[1] = int32(wfd)
+	c.SetRet(0)
+}
@@ -0,0 +1,201 @@
+// pipe implements the buffered unidirectional channel of pipe2,
+// reads and writes block unless the end is non-blocking, and every
+// change of readiness is reported to the callback of the end, which
+// feeds epoll.
+package pipe
+
+import (
+	"io"
+	"sync"
+	"syscall"
+)
+
+const (
+	// Size is the capacity of the buffer
+	Size = 64 << 10
+	// the writes up to PipeBuf bytes are atomic
+	PipeBuf = 4096
+)
+
+type pipe struct {
+	mutex sync.Mutex
+	cond  sync.Cond
+	buf   [Size]byte
+	// the start and the length of the data in buf
+	off, n int
+	// a non-blocking end got EAGAIN and waits for epoll
+	rwait, wwait bool
+
+	r, w *File
+}
+
+// File is an end of a pipe
+type File struct {
+	p        *pipe
+	write    bool
+	closed   bool
+	nonblock bool
+
+	// Notify is called with the EPOLL* events of the end when they happen,
+	// it's called without the pipe lock held.
+	Notify func(events uint32)
+}
+
+// New returns the read and the write end of a pipe
+func New() (*File, *File) {
+	p := new(pipe)
+	p.cond.L = &p.mutex
+	p.r = &File{p: p}
+	p.w = &File{p: p, write: true}
+	return p.r, p.w
+}
+
+func (f *File) notify(events uint32) {
+	if f.Notify != nil {
+		f.Notify(events)
+	}
+}
+
+// SetNonblock sets whether Read and Write return EAGAIN instead of blocking
+func (f *File) SetNonblock(nonblock bool) {
+	f.p.mutex.Lock()
+	f.nonblock = nonblock
+	f.p.mutex.Unlock()
+}
+
+// Nonblock reports whether the end is non-blocking
+func (f *File) Nonblock() bool {
+	f.p.mutex.Lock()
+	defer f.p.mutex.Unlock()
+	return f.nonblock
+}
+
+// Buffered returns the count of bytes ready to be read
+func (f *File) Buffered() int {
+	f.p.mutex.Lock()
+	defer f.p.mutex.Unlock()
+	return f.p.n
+}
+
+// Read returns io.EOF when the buffer is empty and the write end is closed
+func (f *File) Read(b []byte) (int, error) {
+	if f.write {
+		return 0, syscall.EBADF
+	}
+	p := f.p
+	p.mutex.Lock()
+	for p.n == 0 {
+		switch {
+		case f.closed:
+			p.mutex.Unlock()
+			return 0, syscall.EBADF
+		case p.w.closed:
+			p.mutex.Unlock()
+			return 0, io.EOF
+		case len(b) == 0:
+			p.mutex.Unlock()
+			return 0, nil
+		case f.nonblock:
+			p.rwait = true
+			p.mutex.Unlock()
+			return 0, syscall.EAGAIN
+		}
+		p.cond.Wait()
+	}
+	n := 0
+	for n < len(b) && p.n > 0 {
+		end := p.off + p.n
+		if end > Size {
+			end = Size
+		}
+		c := copy(b[n:], p.buf[p.off:end])
+		n += c
+		p.off = (p.off + c) % Size
+		p.n -= c
+	}
+	if p.n == 0 {
+		p.off = 0
+	}
+	p.cond.Broadcast()
+	wake := p.wwait && !p.w.closed
+	p.wwait = false
+	p.mutex.Unlock()
+
+	if wake {
+		p.w.notify(syscall.EPOLLOUT)
+	}
+	return n, nil
+}
+
+// Write blocks until all of b is written unless the end is non-blocking,
+// then it writes what fits, and the writes up to PipeBuf bytes are all or nothing.
+func (f *File) Write(b []byte) (int, error) {
+	if !f.write {
+		return 0, syscall.EBADF
+	}
+	p := f.p
+	p.mutex.Lock()
+	n := 0
+	for n < len(b) {
+		if f.closed {
+			p.mutex.Unlock()
+			return n, syscall.EBADF
+		}
+		if p.r.closed {
+			p.mutex.Unlock()
+			return n, syscall.EPIPE
+		}
+		space := Size - p.n
+		if space == 0 || len(b) <= PipeBuf && space < len(b) {
+			if f.nonblock {
+				p.wwait = true
+				break
+			}
+			p.cond.Wait()
+			continue
+		}
+		for space > 0 && n < len(b) {
+			start := (p.off + p.n) % Size
+			end := start + space
+			if end > Size {
+				end = Size
+			}
+			c := copy(p.buf[start:end], b[n:])
+			n += c
+			p.n += c
+			space -= c
+		}
+		p.cond.Broadcast()
+		if p.rwait {
+			p.rwait = false
+			p.mutex.Unlock()
+			p.r.notify(syscall.EPOLLIN)
+			p.mutex.Lock()
+		}
+	}
+	p.mutex.Unlock()
+	if n == 0 && len(b) != 0 {
+		return 0, syscall.EAGAIN
+	}
+	return n, nil
+}
+
+// Close wakes up the other end, a reader gets EOF and a writer gets EPIPE
+func (f *File) Close() error {
+	p := f.p
+	p.mutex.Lock()
+	if f.closed {
+		p.mutex.Unlock()
+		return syscall.EBADF
+	}
+	f.closed = true
+	p.cond.Broadcast()
+	p.mutex.Unlock()
+
+	if f.write {
+		p.r.notify(syscall.EPOLLIN | syscall.EPOLLHUP)
+	} else {
+		p.w.notify(syscall.EPOLLOUT | syscall.EPOLLERR)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package pipe
+
+import (
+	"bytes"
+	"io"
+	"syscall"
+	"testing"
+)
+
+func TestReadWrite(t *testing.T) {
+	r, w := New()
+	data := bytes.Repeat([]byte("0123456789"), Size/5)
+	go func() {
+		n, err := w.Write(data)
+		if n != len(data) || err != nil {
+			t.Errorf("write %d %v", n, err)
+		}
+		w.Close()
+	}()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("read %d bytes, want %d", len(got), len(data))
+	}
+}
+
+func TestClosedReader(t *testing.T) {
+	r, w := New()
+	r.Close()
+	_, err := w.Write([]byte("x"))
+	if err != syscall.EPIPE {
+		t.Fatalf("got %v, want EPIPE", err)
+	}
+}
+
+func TestNonblock(t *testing.T) {
+	r, w := New()
+	r.SetNonblock(true)
+	w.SetNonblock(true)
+	var revents, wevents uint32
+	r.Notify = func(ev uint32) { revents |= ev }
+	w.Notify = func(ev uint32) { wevents |= ev }
+
+	b := make([]byte, PipeBuf)
+	if _, err := r.Read(b); err != syscall.EAGAIN {
+		t.Fatalf("read empty: %v", err)
+	}
+	if _, err := w.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if revents != syscall.EPOLLIN {
+		t.Fatalf("reader events %#x", revents)
+	}
+
+	n, err := w.Write(make([]byte, Size))
+	if n != Size-5 || err != nil {
+		t.Fatalf("write full: %d %v", n, err)
+	}
+	if _, err := w.Write([]byte("x")); err != syscall.EAGAIN {
+		t.Fatalf("write full: %v", err)
+	}
+	if _, err := r.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	if wevents != syscall.EPOLLOUT {
+		t.Fatalf("writer events %#x", wevents)
+	}
+
+	w.Close()
+	if revents&syscall.EPOLLHUP == 0 {
+		t.Fatalf("reader events %#x", revents)
+	}
+	for {
+		_, err = r.Read(b)
+		if err != nil {
+			break
+		}
+	}
+	if err != io.EOF {
+		t.Fatalf("got %v, want EOF", err)
+	}
+}
+
+func TestAtomicWrite(t *testing.T) {
+	r, w := New()
+	w.SetNonblock(true)
+	w.Write(make([]byte, Size-PipeBuf+1))
+	if _, err := w.Write(make([]byte, PipeBuf)); err != syscall.EAGAIN {
+		t.Fatalf("got %v, want EAGAIN", err)
+	}
+	r.Read(make([]byte, 1))
+	if n, err := w.Write(make([]byte, PipeBuf)); n != PipeBuf || err != nil {
+		t.Fatalf("write %d %v", n, err)
+	}
+}
@@ -0,0 +1,216 @@
+package fs
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/banditmoscow1337/spos/fs/procfs"
+	"github.com/banditmoscow1337/spos/kernel"
+	"github.com/banditmoscow1337/spos/kernel/mm"
+
+	"github.com/klauspost/cpuid"
+	"github.com/spf13/afero"
+)
+
+const (
+	// the clock ticks of the times in stat, the USER_HZ of linux
+	userHZ = 100
+)
+
+var Proc = procfs.New()
+
+// kB converts a count of pages to kilobytes
+func kB(pages int) int {
+	return pages * mm.PGSIZE / 1024
+}
+
+// meminfo uses the keys of linux, the ones without a counterpart are
+// left out except Buffers and Cached which some tools require.
+func meminfo() []byte {
+	s := mm.Stat()
+	var b bytes.Buffer
+	line := func(key string, v int) {
+		fmt.Fprintf(&b, "%-16s%8d kB\n", key+":", v)
+	}
+	line("MemTotal", kB(s.TotalPages))
+	line("MemFree", kB(s.FreePages))
+	line("MemAvailable", kB(s.FreePages))
+	line("Buffers", 0)
+	line("Cached", 0)
+	line("Slab", kB(s.PoolPages))
+	line("SUnreclaim", kB(s.PoolPages))
+	line("PageTables", kB(s.PageTablePages))
+	line("VmallocUsed", int(s.VirtualUsed/1024))
+	line("VmallocFree", int(s.VirtualFree/1024))
+	return b.Bytes()
+}
+
+func hostname() []byte {
+	return []byte(kernel.Hostname() + "\n")
+}
+
+func setHostname(b []byte) error {
+	return kernel.SetHostname(strings.TrimSuffix(string(b), "\n"))
+}
+
+// uptime reports the time since boot and the time spent by the idle threads
+func uptime() []byte {
+	var idle int64
+	for _, t := range kernel.Threads() {
+		if t.Idle {
+			idle += t.Runtime
+		}
+	}
+	return []byte(fmt.Sprintf("%.2f %.2f\n", float64(kernel.Uptime())/1e9, float64(idle)/1e9))
+}
+
+// cpuinfo repeats the cpu found by cpuid for every cpu, they are all alike
+func cpuinfo() []byte {
+	var b bytes.Buffer
+	c := &cpuid.CPU
+	flags := strings.ToLower(strings.Join(c.Features.Strings(), " "))
+	for i := 0; i < kernel.NumCPU(); i++ {
+		fmt.Fprintf(&b, "processor\t: %d\n", i)
+		fmt.Fprintf(&b, "vendor_id\t: %s\n", c.VendorString)
+		fmt.Fprintf(&b, "cpu family\t: %d\n", c.Family)
+		fmt.Fprintf(&b, "model\t\t: %d\n", c.Model)
+		fmt.Fprintf(&b, "model name\t: %s\n", c.BrandName)
+		if c.Hz > 0 {
+			fmt.Fprintf(&b, "cpu MHz\t\t: %.3f\n", float64(c.Hz)/1e6)
+		}
+		if c.Cache.L3 > 0 {
+			fmt.Fprintf(&b, "cache size\t: %d KB\n", c.Cache.L3/1024)
+		}
+		fmt.Fprintf(&b, "siblings\t: %d\n", c.LogicalCores)
+		fmt.Fprintf(&b, "cpu cores\t: %d\n", c.PhysicalCores)
+		fmt.Fprintf(&b, "flags\t\t: %s\n", flags)
+		fmt.Fprintf(&b, "clflush size\t: %d\n", c.CacheLine)
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+// mounts lists the mount points in the format of fstab, the type is the name of the Fs
+func mounts() []byte {
+	var b bytes.Buffer
+	for _, m := range Root.Mounts() {
+		opt := "rw"
+		if _, ok := m.Fs.(*afero.ReadOnlyFs); ok {
+			opt = "ro"
+		}
+		typ := strings.ToLower(m.Fs.Name())
+		fmt.Fprintf(&b, "none %s %s %s 0 0\n", m.Path, typ, opt)
+	}
+	return b.Bytes()
+}
+
+// threadState returns the letter of linux for the state of a thread
+func threadState(state int) (byte, string) {
+	switch state {
+	case kernel.RUNNING, kernel.RUNNABLE:
+		return 'R', "running"
+	case kernel.SLEEPING:
+		return 'S', "sleeping"
+	case kernel.INITING:
+		return 'D', "disk sleep"
+	case kernel.EXIT:
+		return 'Z', "zombie"
+	}
+	return 'X', "dead"
+}
+
+func threadName(t *kernel.ThreadInfo) string {
+	if t.Idle {
+		return fmt.Sprintf("idle/%d", t.CPU)
+	}
+	return "thread"
+}
+
+// tids lists the threads in /proc/self/task
+func tids() []string {
+	var names []string
+	for _, t := range kernel.Threads() {
+		names = append(names, strconv.Itoa(t.Tid))
+	}
+	return names
+}
+
+func findThread(entry string) (*kernel.ThreadInfo, bool) {
+	tid, err := strconv.Atoi(entry)
+	if err != nil {
+		return nil, false
+	}
+	for _, t := range kernel.Threads() {
+		if t.Tid == tid {
+			return &t, true
+		}
+	}
+	return nil, false
+}
+
+// threadStat fills the fields of /proc/<pid>/stat up to stime and processor,
+// the fields in between are 0, the running time is reported as system time.
+func threadStat(entry string) []byte {
+	t, ok := findThread(entry)
+	if !ok {
+		return nil
+	}
+	state, _ := threadState(t.State)
+	stime := t.Runtime / (1e9 / userHZ)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%d (%s) %c 0 %d %d 0 -1 0 0 0 0 0 0 %d", t.Tid, threadName(t), state, t.Tid, t.Tid, stime)
+	// cutime to exit_signal
+	b.WriteString(strings.Repeat(" 0", 38-15))
+	fmt.Fprintf(&b, " %d\n", t.CPU)
+	return b.Bytes()
+}
+
+func threadStatus(entry string) []byte {
+	t, ok := findThread(entry)
+	if !ok {
+		return nil
+	}
+	state, desc := threadState(t.State)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Name:\t%s\n", threadName(t))
+	fmt.Fprintf(&b, "State:\t%c (%s)\n", state, desc)
+	fmt.Fprintf(&b, "Tid:\t%d\n", t.Tid)
+	fmt.Fprintf(&b, "Cpu:\t%d\n", t.CPU)
+	fmt.Fprintf(&b, "Runtime:\t%d ns\n", t.Runtime)
+	return b.Bytes()
+}
+
+// goroutines dumps the stacks of all the goroutines like a panic does
+func goroutines() []byte {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+func procInit() {
+	Proc.Register("meminfo", meminfo)
+	Proc.RegisterWritable("sys/kernel/hostname", hostname, setHostname)
+	Proc.Register("uptime", uptime)
+	Proc.Register("cpuinfo", cpuinfo)
+	Proc.Register("mounts", mounts)
+	Proc.Register("goroutines", goroutines)
+	Proc.RegisterDir("self/task", tids, map[string]procfs.EntryGenerator{
+		"stat":   threadStat,
+		"status": threadStatus,
+	})
+	Proc.Register("interrupts", interrupts)
+	Proc.Register("syscalls", syscalls)
+	Proc.Register("irqstat", irqs)
+	err := Mount("/proc", Proc)
+	if err != nil {
+		panic(err)
+	}
+}
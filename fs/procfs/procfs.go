@@ -0,0 +1,390 @@
+// procfs serves generated files registered by path, the content
+// of a file is snapshotted when it's opened so reads are consistent.
+// Directories are implied by the paths of the files, except the dynamic
+// ones whose entries are listed on every lookup. A few files are writable
+// knobs, every write is passed to the Writer of the file.
+package procfs
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// assert that procfs.Fs implements afero.Fs.
+var _ afero.Fs = (*Fs)(nil)
+
+// Generator returns the content of a file
+type Generator func() []byte
+
+// Writer applies the data written to a file, every write is applied on its own
+type Writer func([]byte) error
+
+// Lister returns the entries of a dynamic directory
+type Lister func() []string
+
+// EntryGenerator returns the content of a file of an entry of a dynamic directory
+type EntryGenerator func(entry string) []byte
+
+type node struct {
+	gen   Generator
+	write Writer
+}
+
+// dynDir is a directory whose entries come and go, like the threads,
+// every entry is a directory of the same files.
+type dynDir struct {
+	list  Lister
+	files map[string]EntryGenerator
+}
+
+type Fs struct {
+	mutex sync.Mutex
+	files map[string]*node
+	dirs  map[string]*dynDir
+	now   time.Time
+}
+
+func New() *Fs {
+	return &Fs{
+		files: make(map[string]*node),
+		dirs:  make(map[string]*dynDir),
+		now:   time.Now(),
+	}
+}
+
+// Register adds the file with the slash separated path name.
+func (f *Fs) Register(name string, gen Generator) {
+	f.RegisterWritable(name, gen, nil)
+}
+
+// RegisterWritable adds the file with the slash separated path name,
+// the data written to it is passed to write.
+func (f *Fs) RegisterWritable(name string, gen Generator, write Writer) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.files[name] = &node{gen: gen, write: write}
+}
+
+// RegisterDir adds the dynamic directory name, the entries returned by list
+// are directories of the files.
+func (f *Fs) RegisterDir(name string, list Lister, files map[string]EntryGenerator) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.dirs[name] = &dynDir{list: list, files: files}
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupDyn looks name up in the dynamic directories, f.mutex must be held
+func (f *Fs) lookupDyn(name string) (*node, bool) {
+	for dname, d := range f.dirs {
+		if name == dname {
+			return nil, true
+		}
+		if !strings.HasPrefix(name, dname+"/") {
+			continue
+		}
+		parts := strings.Split(name[len(dname)+1:], "/")
+		if len(parts) > 2 || !contains(d.list(), parts[0]) {
+			return nil, false
+		}
+		if len(parts) == 1 {
+			return nil, true
+		}
+		gen, ok := d.files[parts[1]]
+		if !ok {
+			return nil, false
+		}
+		entry := parts[0]
+		return &node{gen: func() []byte { return gen(entry) }}, true
+	}
+	return nil, false
+}
+
+// lookup returns the cleaned path of name, and its node if it's a file
+func (f *Fs) lookup(name string) (string, *node, error) {
+	name = strings.TrimPrefix(filepath.Clean("/"+name), "/")
+	if name == "" {
+		return "", nil, nil
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if n, ok := f.files[name]; ok {
+		return name, n, nil
+	}
+	if n, ok := f.lookupDyn(name); ok {
+		return name, n, nil
+	}
+	// the parents of the files and the dynamic directories
+	for fname := range f.files {
+		if strings.HasPrefix(fname, name+"/") {
+			return name, nil, nil
+		}
+	}
+	for dname := range f.dirs {
+		if strings.HasPrefix(dname, name+"/") {
+			return name, nil, nil
+		}
+	}
+	return "", nil, os.ErrNotExist
+}
+
+func (f *Fs) Name() string { return "procfs" }
+
+func (f *Fs) Create(name string) (afero.File, error) {
+	return f.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+func (f *Fs) Open(name string) (afero.File, error) {
+	return f.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (f *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	fname, n, err := f.lookup(name)
+	writing := flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) != 0
+	if err == nil && writing && (n == nil || n.write == nil) {
+		err = syscall.EACCES
+	}
+	if err != nil {
+		if flag&os.O_CREATE != 0 {
+			err = syscall.EROFS
+		}
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	if n == nil {
+		return &dir{fs: f, name: fname}, nil
+	}
+	var content []byte
+	if flag&os.O_TRUNC == 0 {
+		content = n.gen()
+	}
+	return &file{name: fname, Reader: bytes.NewReader(content), write: n.write, modTime: time.Now()}, nil
+}
+
+func (f *Fs) Stat(name string) (os.FileInfo, error) {
+	fname, n, err := f.lookup(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+	if n == nil {
+		return newDirInfo(fname, f.now), nil
+	}
+	// the size is unknown until the file is generated, it's 0 like on linux
+	return &fileInfo{name: filepath.Base(fname), mode: n.mode(), modTime: time.Now()}, nil
+}
+
+func (n *node) mode() os.FileMode {
+	if n.write != nil {
+		return 0644
+	}
+	return 0444
+}
+
+func (f *Fs) Mkdir(name string, perm os.FileMode) error {
+	return &os.PathError{Op: "mkdir", Path: name, Err: syscall.EROFS}
+}
+
+func (f *Fs) MkdirAll(path string, perm os.FileMode) error {
+	return f.Mkdir(path, perm)
+}
+
+func (f *Fs) Remove(name string) error {
+	return &os.PathError{Op: "remove", Path: name, Err: syscall.EROFS}
+}
+
+func (f *Fs) RemoveAll(path string) error {
+	return f.Remove(path)
+}
+
+func (f *Fs) Rename(oldname, newname string) error {
+	return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EROFS}
+}
+
+func (f *Fs) Chmod(name string, mode os.FileMode) error {
+	return &os.PathError{Op: "chmod", Path: name, Err: syscall.EROFS}
+}
+
+func (f *Fs) Chown(name string, uid, gid int) error {
+	return &os.PathError{Op: "chown", Path: name, Err: syscall.EROFS}
+}
+
+func (f *Fs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return &os.PathError{Op: "chtimes", Path: name, Err: syscall.EROFS}
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func newDirInfo(name string, modTime time.Time) *fileInfo {
+	return &fileInfo{name: filepath.Base("/" + name), mode: os.ModeDir | 0555, modTime: modTime}
+}
+
+func (i *fileInfo) Name() string       { return i.name }
+func (i *fileInfo) Size() int64        { return i.size }
+func (i *fileInfo) Mode() os.FileMode  { return i.mode }
+func (i *fileInfo) ModTime() time.Time { return i.modTime }
+func (i *fileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *fileInfo) Sys() interface{}   { return nil }
+
+// file is an opened snapshot of a generated file.
+type file struct {
+	*bytes.Reader
+	name    string
+	write   Writer
+	modTime time.Time
+}
+
+func (d *file) Name() string { return d.name }
+
+func (d *file) Write(p []byte) (int, error) {
+	if d.write == nil {
+		return 0, syscall.EBADF
+	}
+	if err := d.write(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (d *file) WriteAt(p []byte, off int64) (int, error) { return d.Write(p) }
+func (d *file) WriteString(s string) (int, error)        { return d.Write([]byte(s)) }
+func (d *file) Readdir(count int) ([]os.FileInfo, error) { return nil, syscall.ENOTDIR }
+func (d *file) Readdirnames(n int) ([]string, error)     { return nil, syscall.ENOTDIR }
+func (d *file) Sync() error                              { return nil }
+func (d *file) Close() error                             { return nil }
+
+// Truncate is a no-op on a writable file, the write replaces the content
+func (d *file) Truncate(size int64) error {
+	if d.write == nil {
+		return syscall.EROFS
+	}
+	return nil
+}
+
+func (d *file) Stat() (os.FileInfo, error) {
+	n := node{write: d.write}
+	return &fileInfo{name: filepath.Base(d.name), size: d.Size(), mode: n.mode(), modTime: d.modTime}, nil
+}
+
+// dir is an opened directory, name is "" for the root.
+type dir struct {
+	fs   *Fs
+	name string
+	off  int
+}
+
+// entries returns the sorted names of the children, and whether each one is a directory
+func (d *dir) entries() ([]string, map[string]bool) {
+	prefix := d.name + "/"
+	if d.name == "" {
+		prefix = ""
+	}
+	d.fs.mutex.Lock()
+	defer d.fs.mutex.Unlock()
+	isdir := make(map[string]bool)
+	add := func(path string, dir bool) {
+		if !strings.HasPrefix(path, prefix) {
+			return
+		}
+		child := path[len(prefix):]
+		if i := strings.IndexByte(child, '/'); i >= 0 {
+			isdir[child[:i]] = true
+		} else if _, ok := isdir[child]; !ok || dir {
+			isdir[child] = dir
+		}
+	}
+	for fname := range d.fs.files {
+		add(fname, false)
+	}
+	for dname, dyn := range d.fs.dirs {
+		add(dname, true)
+		switch {
+		case dname == d.name:
+			for _, entry := range dyn.list() {
+				isdir[entry] = true
+			}
+		case strings.HasPrefix(d.name, dname+"/"):
+			for fname := range dyn.files {
+				isdir[fname] = false
+			}
+		}
+	}
+	names := make([]string, 0, len(isdir))
+	for name := range isdir {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, isdir
+}
+
+func (d *dir) names() []string {
+	names, _ := d.entries()
+	return names
+}
+
+func (d *dir) Name() string { return "/" + d.name }
+
+func (d *dir) Readdirnames(n int) ([]string, error) {
+	names := d.names()
+	if d.off >= len(names) {
+		names = nil
+	} else {
+		names = names[d.off:]
+	}
+	if n > 0 && len(names) > n {
+		names = names[:n]
+	}
+	d.off += len(names)
+	if n > 0 && len(names) == 0 {
+		return nil, io.EOF
+	}
+	return names, nil
+}
+
+func (d *dir) Readdir(count int) ([]os.FileInfo, error) {
+	names, err := d.Readdirnames(count)
+	_, isdir := d.entries()
+	infos := make([]os.FileInfo, 0, len(names))
+	for _, name := range names {
+		mode := os.FileMode(0444)
+		if isdir[name] {
+			mode = os.ModeDir | 0555
+		}
+		infos = append(infos, &fileInfo{name: name, mode: mode, modTime: d.fs.now})
+	}
+	return infos, err
+}
+
+func (d *dir) Stat() (os.FileInfo, error) {
+	return newDirInfo(d.name, d.fs.now), nil
+}
+
+func (d *dir) Read(p []byte) (int, error)                   { return 0, syscall.EISDIR }
+func (d *dir) ReadAt(p []byte, off int64) (int, error)      { return 0, syscall.EISDIR }
+func (d *dir) Write(p []byte) (int, error)                  { return 0, syscall.EISDIR }
+func (d *dir) WriteAt(p []byte, off int64) (int, error)     { return 0, syscall.EISDIR }
+func (d *dir) WriteString(s string) (int, error)            { return 0, syscall.EISDIR }
+func (d *dir) Seek(offset int64, whence int) (int64, error) { d.off = 0; return 0, nil }
+func (d *dir) Sync() error                                  { return nil }
+func (d *dir) Truncate(size int64) error                    { return syscall.EISDIR }
+func (d *dir) Close() error                                 { return nil }
@@ -0,0 +1,105 @@
+package fs
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/banditmoscow1337/spos/drivers/apic"
+	"github.com/banditmoscow1337/spos/drivers/irq"
+	"github.com/banditmoscow1337/spos/kernel"
+)
+
+// vectorName describes the trap vector in the last column of /proc/interrupts
+func vectorName(v int) string {
+	switch {
+	case v < 32:
+		return "exception"
+	case v >= irq.IRQ_BASE && v < irq.IRQ_BASE+irq.NR_IRQS:
+		return fmt.Sprintf("IRQ-%d", v-irq.IRQ_BASE)
+	case v == apic.TimerVector:
+		return "Local timer interrupts"
+	case v == apic.SpuriousVector:
+		return "Spurious interrupts"
+	}
+	return ""
+}
+
+// interrupts lists the trap vectors taken at least once with the count of every cpu
+func interrupts() []byte {
+	var b bytes.Buffer
+	ncpu := len(kernel.TrapCounts(0))
+	b.WriteString("    ")
+	for i := 0; i < ncpu; i++ {
+		fmt.Fprintf(&b, " %10s", fmt.Sprintf("CPU%d", i))
+	}
+	b.WriteByte('\n')
+	for v := 0; v < 256; v++ {
+		counts := kernel.TrapCounts(v)
+		var total uint64
+		for _, c := range counts {
+			total += c
+		}
+		if total == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "%3d:", v)
+		for _, c := range counts {
+			fmt.Fprintf(&b, " %10d", c)
+		}
+		fmt.Fprintf(&b, "   %s\n", vectorName(v))
+	}
+	return b.Bytes()
+}
+
+// histLabel is the approximate upper bound of the histogram bucket i,
+// the powers of 1024 are rounded to the next unit.
+func histLabel(i int) string {
+	if i == kernel.HistBuckets-1 {
+		return "inf"
+	}
+	shift := i + 10
+	return fmt.Sprintf("%d%s", 1<<(shift%10), [...]string{"ns", "us", "ms", "s"}[shift/10])
+}
+
+// writeCallStats writes a header and a line for every stat, the histogram
+// columns are the counts of the calls shorter than the label.
+func writeCallStats(b *bytes.Buffer, key string, names []string, stats []kernel.CallStat) {
+	fmt.Fprintf(b, "%-20s %10s %12s %10s %10s", key, "calls", "total_us", "avg_us", "max_us")
+	for i := 0; i < kernel.HistBuckets; i++ {
+		fmt.Fprintf(b, " %7s", histLabel(i))
+	}
+	b.WriteByte('\n')
+	for i := range stats {
+		s := &stats[i]
+		fmt.Fprintf(b, "%-20s %10d %12d %10d %10d", names[i], s.Count, s.Total/1000, s.Avg()/1000, s.Max/1000)
+		for _, c := range s.Hist {
+			fmt.Fprintf(b, " %7d", c)
+		}
+		b.WriteByte('\n')
+	}
+}
+
+func syscalls() []byte {
+	var names []string
+	var stats []kernel.CallStat
+	for _, s := range kernel.SyscallStats() {
+		names = append(names, kernel.SyscallName(s.No))
+		stats = append(stats, s.CallStat)
+	}
+	var b bytes.Buffer
+	writeCallStats(&b, "syscall", names, stats)
+	return b.Bytes()
+}
+
+// irqs is the time spent by the irq handlers in the trap thread
+func irqs() []byte {
+	var names []string
+	var stats []kernel.CallStat
+	for _, s := range kernel.IRQStats() {
+		names = append(names, fmt.Sprintf("IRQ-%d", s.Line))
+		stats = append(stats, s.CallStat)
+	}
+	var b bytes.Buffer
+	writeCallStats(&b, "irq", names, stats)
+	return b.Bytes()
+}
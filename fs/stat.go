@@ -0,0 +1,121 @@
+package fs
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/banditmoscow1337/spos/fs/pipe"
+
+	"github.com/spf13/afero"
+)
+
+const (
+	statBlksize = 4096
+	// st_blocks counts 512 byte blocks whatever the blksize is
+	statBlockSize = 512
+)
+
+var (
+	devLock sync.Mutex
+	// the minor of the anonymous device of every mount point, in the order
+	// they are first stat'ed
+	devids = make(map[string]uint64)
+)
+
+// mkdev encodes a device number like the makedev of glibc
+func mkdev(major, minor uint64) uint64 {
+	return major&0xfff<<8 | major&^0xfff<<32 | minor&0xff | minor&^0xff<<12
+}
+
+// devID returns the device of the mount serving path, all of them are
+// anonymous devices of major 0 like the ones of tmpfs.
+func devID(path string) uint64 {
+	mnt := Root.MountPoint(path)
+	devLock.Lock()
+	defer devLock.Unlock()
+	minor, ok := devids[mnt]
+	if !ok {
+		minor = uint64(len(devids) + 1)
+		devids[mnt] = minor
+	}
+	return mkdev(0, minor)
+}
+
+// statMode converts the mode of Go to st_mode
+func statMode(mode os.FileMode) uint32 {
+	m := uint32(mode.Perm())
+	switch {
+	case mode.IsDir():
+		m |= syscall.S_IFDIR
+	case mode&os.ModeSymlink != 0:
+		m |= syscall.S_IFLNK
+	case mode&os.ModeNamedPipe != 0:
+		m |= syscall.S_IFIFO
+	case mode&os.ModeSocket != 0:
+		m |= syscall.S_IFSOCK
+	case mode&os.ModeCharDevice != 0:
+		m |= syscall.S_IFCHR
+	case mode&os.ModeDevice != 0:
+		m |= syscall.S_IFBLK
+	default:
+		m |= syscall.S_IFREG
+	}
+	if mode&os.ModeSetuid != 0 {
+		m |= syscall.S_ISUID
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= syscall.S_ISGID
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= syscall.S_ISVTX
+	}
+	return m
+}
+
+// fillStat fills stat with the info of the file at path, afero keeps
+// only the modification time so it's used for all the timestamps.
+func fillStat(stat *syscall.Stat_t, path string, info os.FileInfo) {
+	*stat = syscall.Stat_t{}
+	stat.Dev = devID(path)
+	stat.Ino = inodeNumber(path)
+	stat.Mode = statMode(info.Mode())
+	stat.Nlink = 1
+	if info.IsDir() {
+		// . and the entry in the parent
+		stat.Nlink = 2
+	}
+	stat.Size = info.Size()
+	stat.Blksize = statBlksize
+	stat.Blocks = (stat.Size + statBlockSize - 1) / statBlockSize
+	ts := syscall.NsecToTimespec(info.ModTime().UnixNano())
+	stat.Atim = ts
+	stat.Mtim = ts
+	stat.Ctim = ts
+}
+
+// fillFileStat fills stat with the info of an open file, the ones
+// outside of the file tree are pipes, sockets or the console.
+func fillFileStat(stat *syscall.Stat_t, ni *Inode) error {
+	if f, ok := ni.File.(afero.File); ok && ni.path != "" {
+		info, err := f.Stat()
+		if err != nil {
+			return errno(err)
+		}
+		fillStat(stat, ni.path, info)
+		return nil
+	}
+	*stat = syscall.Stat_t{}
+	stat.Nlink = 1
+	stat.Blksize = statBlksize
+	stat.Mode = syscall.S_IFCHR | 0620
+	if _, ok := ni.File.(*pipe.File); ok {
+		stat.Mode = syscall.S_IFIFO | 0600
+		stat.Blksize = pipe.PipeBuf
+	} else if s, ok := ni.File.(Socket); ok && s.IsSocket() {
+		stat.Mode = syscall.S_IFSOCK | 0777
+	}
+	stat.Ino = inodeNumber(fmt.Sprintf("anon:[%p]", ni.File))
+	return nil
+}
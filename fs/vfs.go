@@ -2,23 +2,41 @@ package fs
 
 import (
 	"io"
-	"math/rand"
 	"os"
+	"path/filepath"
 	"sync"
 	"syscall"
 	"unsafe"
 
 	"github.com/banditmoscow1337/spos/console"
 	"github.com/banditmoscow1337/spos/fs/mount"
+	"github.com/banditmoscow1337/spos/kernel"
 	"github.com/banditmoscow1337/spos/kernel/isyscall"
 	"github.com/banditmoscow1337/spos/kernel/sys"
 
 	"github.com/spf13/afero"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// the max count of iovecs of readv and writev
+	_IOV_MAX = 1024
+	// the size of the fd events of the kernel epoll
+	_MAX_FDS = 1024
+
+	// the flags F_SETFL changes
+	_STATUS_FLAGS = syscall.O_APPEND | syscall.O_NONBLOCK
+
+	// the fds of the kernel epoll and the pipe of the netpoller,
+	// they can't be closed or replaced by dup2
+	_EPOLL_FD      = 3
+	_PIPE_WRITE_FD = 5
 )
 
 var (
 	inodeLock sync.Mutex
-	inodes    []*Inode
+	// indexed by fd, the dup'ed fds share the inode
+	fdtable []fdEntry
 
 	Root = mount.NewMountableFs(afero.NewMemMapFs())
 )
@@ -27,42 +45,81 @@ type Ioctler interface {
 	Ioctl(op, arg uintptr) error
 }
 
-type Inode struct {
-	File  io.ReadWriteCloser
-	Fd    int
-	inuse bool
+// Socket is the file of a socket, fstat reports it as S_IFSOCK
+type Socket interface {
+	IsSocket() bool
 }
 
-func (i *Inode) Release() {
-	inodeLock.Lock()
-	defer inodeLock.Unlock()
+// Nonblocker is a file which can return EAGAIN instead of blocking
+type Nonblocker interface {
+	SetNonblock(bool)
+	Nonblock() bool
+}
 
-	i.inuse = false
-	i.File = nil
-	i.Fd = -1
+// Inode is an open file description, shared by the fds dup'ed from the same fd.
+type Inode struct {
+	File io.ReadWriteCloser
+	// the fd the file is opened at
+	Fd int
+	// the count of the fds referring to the inode
+	refs int
+	// the access mode and the status flags of open, guarded by mutex
+	flags int
+	// serializes the pread and pwrite emulated with Seek, the appends, and getdents
+	mutex sync.Mutex
+
+	// the absolute path the file is opened with, empty for pipes and devices
+	path string
+	// the entries of a directory read by getdents, and the next one
+	dirents []os.FileInfo
+	dirpos  int
 }
 
-func AllocInode() (int, *Inode) {
-	inodeLock.Lock()
-	defer inodeLock.Unlock()
+// fdEntry is a slot of the fd table, it's free if ni is nil
+type fdEntry struct {
+	ni      *Inode
+	cloexec bool
+}
 
-	var fd int
-	var ni *Inode
-	for i := range inodes {
-		entry := inodes[i]
-		if !entry.inuse {
-			fd = i
-			ni = entry
-			break
-		}
+// installFd puts ni at the lowest free fd not less than min, inodeLock must be held.
+func installFd(ni *Inode, min int, cloexec bool) (int, error) {
+	fd := min
+	for fd < len(fdtable) && fdtable[fd].ni != nil {
+		fd++
 	}
-	if fd == 0 {
-		ni = new(Inode)
-		fd = len(inodes)
-		inodes = append(inodes, ni)
+	if fd >= _MAX_FDS {
+		return -1, syscall.EMFILE
+	}
+	for fd >= len(fdtable) {
+		fdtable = append(fdtable, fdEntry{})
+	}
+	fdtable[fd] = fdEntry{ni: ni, cloexec: cloexec}
+	ni.refs++
+	return fd, nil
+}
+
+// allocFile opens f at the lowest free fd with the access mode and
+// the flags of open.
+func allocFile(f io.ReadWriteCloser, flags int) (int, *Inode, error) {
+	ni := &Inode{File: f}
+	ni.setFlags(flags & (syscall.O_ACCMODE | _STATUS_FLAGS))
+	inodeLock.Lock()
+	fd, err := installFd(ni, 0, flags&syscall.O_CLOEXEC != 0)
+	inodeLock.Unlock()
+	if err != nil {
+		return -1, nil, err
 	}
-	ni.inuse = true
 	ni.Fd = fd
+	return fd, ni, nil
+}
+
+// AllocInode returns a read-write inode at the lowest free fd,
+// the fd is -1 if there are too many open files.
+func AllocInode() (int, *Inode) {
+	fd, ni, err := allocFile(nil, syscall.O_RDWR)
+	if err != nil {
+		return -1, &Inode{}
+	}
 	return fd, ni
 }
 
@@ -76,14 +133,87 @@ func GetInode(fd int) (*Inode, error) {
 	inodeLock.Lock()
 	defer inodeLock.Unlock()
 
-	if fd >= len(inodes) || fd < 0 {
+	if fd >= len(fdtable) || fd < 0 || fdtable[fd].ni == nil {
 		return nil, syscall.EBADF
 	}
-	ni := inodes[fd]
-	if !ni.inuse {
-		return nil, syscall.EBADF
+	return fdtable[fd].ni, nil
+}
+
+// reservedFd reports whether fd belongs to the kernel
+func reservedFd(fd int) bool {
+	return fd >= _EPOLL_FD && fd <= _PIPE_WRITE_FD
+}
+
+// closeFd frees the fd, the file is closed with the last fd referring to it
+func closeFd(fd int) error {
+	if reservedFd(fd) {
+		return syscall.EBADF
+	}
+	inodeLock.Lock()
+	if fd >= len(fdtable) || fd < 0 || fdtable[fd].ni == nil {
+		inodeLock.Unlock()
+		return syscall.EBADF
+	}
+	ni := fdtable[fd].ni
+	fdtable[fd] = fdEntry{}
+	ni.refs--
+	last := ni.refs == 0
+	inodeLock.Unlock()
+
+	if !last || ni.File == nil {
+		return nil
+	}
+	return ni.File.Close()
+}
+
+// Notify reports the EPOLL* events of the file to the kernel epoll,
+// every fd referring to the file gets them.
+func (ni *Inode) Notify(events uint32) {
+	var fds []int
+	inodeLock.Lock()
+	for fd := range fdtable {
+		if fdtable[fd].ni == ni {
+			fds = append(fds, fd)
+		}
+	}
+	inodeLock.Unlock()
+	for _, fd := range fds {
+		syscall.Syscall(kernel.SYS_EPOLL_NOTIFY, uintptr(fd), uintptr(events), 0)
+	}
+}
+
+// Flags returns the access mode and the status flags of the file
+func (ni *Inode) Flags() int {
+	ni.mutex.Lock()
+	defer ni.mutex.Unlock()
+	return ni.flags
+}
+
+// setFlags sets the status flags, the access mode is kept after open
+func (ni *Inode) setFlags(flags int) {
+	ni.mutex.Lock()
+	ni.flags = flags
+	ni.mutex.Unlock()
+	if nb, ok := ni.File.(Nonblocker); ok {
+		nb.SetNonblock(flags&syscall.O_NONBLOCK != 0)
+	}
+}
+
+// SetNonblock sets O_NONBLOCK, files which block honour it by implementing Nonblocker
+func (ni *Inode) SetNonblock(nonblock bool) {
+	flags := ni.Flags() &^ syscall.O_NONBLOCK
+	if nonblock {
+		flags |= syscall.O_NONBLOCK
 	}
-	return ni, nil
+	ni.setFlags(flags)
+}
+
+func (ni *Inode) readable() bool {
+	return ni.Flags()&syscall.O_ACCMODE != syscall.O_WRONLY
+}
+
+func (ni *Inode) writable() bool {
+	return ni.Flags()&syscall.O_ACCMODE != syscall.O_RDONLY
 }
 
 func fscall(fn int) isyscall.Handler {
@@ -120,42 +250,85 @@ func fscall(fn int) isyscall.Handler {
 			n, err = sysWrite(ni, c.Arg(1), c.Arg(2))
 			c.SetRet(uintptr(n))
 		case syscall.SYS_CLOSE:
-			err = sysClose(ni)
+			err = closeFd(int(c.Arg(0)))
 		case syscall.SYS_FSTAT:
 			err = sysStat(ni, c.Arg(1))
 		case syscall.SYS_IOCTL:
 			err = sysIoctl(ni, c.Arg(1), c.Arg(2))
+		case syscall.SYS_LSEEK:
+			var off int64
+			off, err = sysLseek(ni, int64(c.Arg(1)), int(c.Arg(2)))
+			c.SetRet(uintptr(off))
+		case syscall.SYS_PREAD64:
+			var n int
+			n, err = sysPread(ni, c.Arg(1), c.Arg(2), int64(c.Arg(3)))
+			c.SetRet(uintptr(n))
+		case syscall.SYS_PWRITE64:
+			var n int
+			n, err = sysPwrite(ni, c.Arg(1), c.Arg(2), int64(c.Arg(3)))
+			c.SetRet(uintptr(n))
+		case syscall.SYS_READV:
+			var n int
+			n, err = sysReadv(ni, c.Arg(1), c.Arg(2))
+			c.SetRet(uintptr(n))
+		case syscall.SYS_WRITEV:
+			var n int
+			n, err = sysWritev(ni, c.Arg(1), c.Arg(2))
+			c.SetRet(uintptr(n))
+		case unix.SYS_GETDENTS64:
+			var n int
+			n, err = sysGetdents64(ni, c.Arg(1), c.Arg(2))
+			c.SetRet(uintptr(n))
 		}
 
 		if err != nil {
-			c.SetError(err)
+			c.SetErrorNO(errno(err))
 		}
 
 	}
 }
 
 func sysOpen(dirfd, name, flags, perm uintptr) (int, error) {
-	path := cstring(name)
-	f, err := Root.OpenFile(path, int(flags), os.FileMode(perm))
+	path, err := resolve(int(dirfd), cstring(name))
 	if err != nil {
-		if os.IsNotExist(err) {
-			return 0, syscall.ENOENT
+		return 0, err
+	}
+	info, err := Root.Stat(path)
+	switch {
+	case err == nil && flags&syscall.O_DIRECTORY != 0 && !info.IsDir():
+		return 0, syscall.ENOTDIR
+	case err == nil && info.IsDir() && flags&syscall.O_ACCMODE != syscall.O_RDONLY:
+		return 0, syscall.EISDIR
+	case err != nil && flags&syscall.O_CREAT != 0:
+		// afero makes the missing parents
+		if err := isDir(filepath.Dir(path)); err != nil {
+			return 0, err
 		}
+	}
+	// the flags afero doesn't know about
+	aflags := flags &^ (syscall.O_CLOEXEC | syscall.O_DIRECTORY | syscall.O_NOFOLLOW |
+		syscall.O_LARGEFILE | syscall.O_NONBLOCK)
+	f, err := Root.OpenFile(path, int(aflags), os.FileMode(perm))
+	if err != nil {
+		return 0, errno(err)
+	}
+	fd, ni, err := allocFile(f, int(flags))
+	if err != nil {
+		f.Close()
 		return 0, err
 	}
-	fd, ni := AllocInode()
-	ni.File = f
+	ni.path = path
 	return fd, nil
 }
 
-func sysClose(ni *Inode) error {
-	err := ni.File.Close()
-	ni.Release()
-	return err
+func sysRead(ni *Inode, p, n uintptr) (int, error) {
+	return readFile(ni, sys.UnsafeBuffer(p, int(n)))
 }
 
-func sysRead(ni *Inode, p, n uintptr) (int, error) {
-	buf := sys.UnsafeBuffer(p, int(n))
+func readFile(ni *Inode, buf []byte) (int, error) {
+	if !ni.readable() {
+		return 0, syscall.EBADF
+	}
 	ret, err := ni.File.Read(buf)
 
 	switch {
@@ -171,7 +344,23 @@ func sysRead(ni *Inode, p, n uintptr) (int, error) {
 }
 
 func sysWrite(ni *Inode, p, n uintptr) (int, error) {
-	buf := sys.UnsafeBuffer(p, int(n))
+	return writeFile(ni, sys.UnsafeBuffer(p, int(n)))
+}
+
+func writeFile(ni *Inode, buf []byte) (int, error) {
+	if !ni.writable() {
+		return 0, syscall.EBADF
+	}
+	seeker, ok := ni.File.(io.Seeker)
+	if ok && ni.Flags()&syscall.O_APPEND != 0 {
+		// every write goes to the end, not only the first one like afero does
+		ni.mutex.Lock()
+		defer ni.mutex.Unlock()
+		_, err := seeker.Seek(0, io.SeekEnd)
+		if err != nil {
+			return 0, errno(err)
+		}
+	}
 	_n, err := ni.File.Write(buf)
 	if _n != 0 {
 		return _n, nil
@@ -179,21 +368,179 @@ func sysWrite(ni *Inode, p, n uintptr) (int, error) {
 	return 0, err
 }
 
-func sysStat(ni *Inode, statptr uintptr) error {
-	file, ok := ni.File.(afero.File)
+func sysLseek(ni *Inode, off int64, whence int) (int64, error) {
+	ni.mutex.Lock()
+	if ni.dirents != nil {
+		// rewinddir, the entries are read again
+		ni.dirents = nil
+		ni.dirpos = 0
+	}
+	ni.mutex.Unlock()
+	seeker, ok := ni.File.(io.Seeker)
 	if !ok {
-		return syscall.EINVAL
+		return 0, syscall.ESPIPE
 	}
-	stat := (*syscall.Stat_t)(unsafe.Pointer(statptr))
-	info, err := file.Stat()
+	if whence > io.SeekEnd {
+		return 0, syscall.EINVAL
+	}
+	prev, err := seeker.Seek(0, io.SeekCurrent)
 	if err != nil {
-		return err
+		return 0, syscall.EBADF
+	}
+	ret, err := seeker.Seek(off, whence)
+	if err != nil {
+		if _, ok := err.(syscall.Errno); !ok {
+			err = syscall.EINVAL
+		}
+		return 0, err
+	}
+	// some files of afero accept the negative offsets
+	if ret < 0 {
+		seeker.Seek(prev, io.SeekStart)
+		return 0, syscall.EINVAL
 	}
-	stat.Mode = uint32(info.Mode())
-	stat.Mtim.Sec = int64(info.ModTime().Unix())
-	stat.Size = info.Size()
+	return ret, nil
+}
+
+func sysPread(ni *Inode, p, n uintptr, off int64) (int, error) {
+	if off < 0 {
+		return 0, syscall.EINVAL
+	}
+	if !ni.readable() {
+		return 0, syscall.EBADF
+	}
+	buf := sys.UnsafeBuffer(p, int(n))
+	var ret int
+	var err error
+	switch f := ni.File.(type) {
+	case io.ReaderAt:
+		ret, err = f.ReadAt(buf, off)
+	case io.ReadSeeker:
+		// the offset of the file is kept as pread requires
+		ni.mutex.Lock()
+		ret, err = seekDo(f, off, func() (int, error) { return f.Read(buf) })
+		ni.mutex.Unlock()
+	default:
+		return 0, syscall.ESPIPE
+	}
+	switch {
+	case ret != 0:
+		return ret, nil
+	case err == io.EOF:
+		return 0, nil
+	default:
+		return 0, err
+	}
+}
 
-	return nil
+func sysPwrite(ni *Inode, p, n uintptr, off int64) (int, error) {
+	if off < 0 {
+		return 0, syscall.EINVAL
+	}
+	if !ni.writable() {
+		return 0, syscall.EBADF
+	}
+	buf := sys.UnsafeBuffer(p, int(n))
+	var ret int
+	var err error
+	switch f := ni.File.(type) {
+	case io.WriterAt:
+		ret, err = f.WriteAt(buf, off)
+	case io.WriteSeeker:
+		ni.mutex.Lock()
+		ret, err = seekDo(f, off, func() (int, error) { return f.Write(buf) })
+		ni.mutex.Unlock()
+	default:
+		return 0, syscall.ESPIPE
+	}
+	if ret != 0 {
+		return ret, nil
+	}
+	return 0, err
+}
+
+// seekDo calls fn at the offset off of f, and restores the offset of f after
+func seekDo(f io.Seeker, off int64, fn func() (int, error)) (int, error) {
+	cur, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	_, err = f.Seek(off, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	n, err := fn()
+	f.Seek(cur, io.SeekStart)
+	return n, err
+}
+
+// iovecs returns the buffers of the iovec array at p
+func iovecs(p, cnt uintptr) ([][]byte, error) {
+	if cnt > _IOV_MAX {
+		return nil, syscall.EINVAL
+	}
+	if cnt == 0 {
+		return nil, nil
+	}
+	vecs := (*[_IOV_MAX]syscall.Iovec)(unsafe.Pointer(p))[:cnt:cnt]
+	bufs := make([][]byte, 0, cnt)
+	for _, v := range vecs {
+		if v.Len == 0 {
+			continue
+		}
+		bufs = append(bufs, sys.UnsafeBuffer(uintptr(unsafe.Pointer(v.Base)), int(v.Len)))
+	}
+	return bufs, nil
+}
+
+// sysReadv stops at the first short read, like readv does with a pipe or a socket
+func sysReadv(ni *Inode, p, cnt uintptr) (int, error) {
+	bufs, err := iovecs(p, cnt)
+	if err != nil {
+		return 0, err
+	}
+	var total int
+	for _, buf := range bufs {
+		n, err := readFile(ni, buf)
+		total += n
+		if err != nil {
+			if total != 0 {
+				return total, nil
+			}
+			return 0, err
+		}
+		if n < len(buf) {
+			break
+		}
+	}
+	return total, nil
+}
+
+func sysWritev(ni *Inode, p, cnt uintptr) (int, error) {
+	bufs, err := iovecs(p, cnt)
+	if err != nil {
+		return 0, err
+	}
+	var total int
+	for _, buf := range bufs {
+		n, err := writeFile(ni, buf)
+		total += n
+		if err != nil {
+			if total != 0 {
+				return total, nil
+			}
+			return 0, err
+		}
+		if n < len(buf) {
+			break
+		}
+	}
+	return total, nil
+}
+
+func sysStat(ni *Inode, statptr uintptr) error {
+	stat := (*syscall.Stat_t)(unsafe.Pointer(statptr))
+	return fillFileStat(stat, ni)
 }
 
 func sysIoctl(ni *Inode, op, arg uintptr) error {
@@ -204,19 +551,16 @@ func sysIoctl(ni *Inode, op, arg uintptr) error {
 	return ctl.Ioctl(op, arg)
 }
 
-func sysFcntl(call *isyscall.Request) {
-	call.SetRet(0)
-}
-
 // func Uname(buf *Utsname)
 func sysUname(c *isyscall.Request) {
 	unsafebuf := func(b *[65]int8) []byte {
 		return (*[65]byte)(unsafe.Pointer(b))[:]
 	}
 	buf := (*syscall.Utsname)(unsafe.Pointer(c.Arg(0)))
+	*buf = syscall.Utsname{}
 	copy(unsafebuf(&buf.Machine), "x86_32")
 	copy(unsafebuf(&buf.Domainname), "icexin.com")
-	copy(unsafebuf(&buf.Nodename), "icexin.local")
+	copy(unsafebuf(&buf.Nodename), kernel.Hostname())
 	copy(unsafebuf(&buf.Release), "0")
 	copy(unsafebuf(&buf.Sysname), "spos")
 	copy(unsafebuf(&buf.Version), "0")
@@ -224,48 +568,66 @@ func sysUname(c *isyscall.Request) {
 
 }
 
+// func sethostname(name *byte, len int)
+func sysSethostname(c *isyscall.Request) {
+	if c.Arg(1) > 64 {
+		c.SetErrorNO(syscall.EINVAL)
+		return
+	}
+	name := sys.UnsafeBuffer(c.Arg(0), int(c.Arg(1)))
+	c.SetError(kernel.SetHostname(string(name)))
+}
+
 // func fstatat(dirfd int, path string, stat *Stat_t, flags int)
 func sysFstatat64(c *isyscall.Request) {
-	name := cstring(c.Arg(1))
+	dirfd, flags := int(c.Arg(0)), c.Arg(3)
 	stat := (*syscall.Stat_t)(unsafe.Pointer(c.Arg(2)))
-	info, err := Root.Stat(name)
-	if err != nil {
-		if os.IsNotExist(err) {
-			c.SetRet(isyscall.Errno(syscall.ENOENT))
+	if flags&^(unix.AT_SYMLINK_NOFOLLOW|unix.AT_EMPTY_PATH|unix.AT_NO_AUTOMOUNT) != 0 {
+		c.SetErrorNO(syscall.EINVAL)
+		return
+	}
+	name := cstring(c.Arg(1))
+	if name == "" && flags&unix.AT_EMPTY_PATH != 0 {
+		if dirfd == unix.AT_FDCWD {
+			name = "."
 		} else {
-			c.SetRet(isyscall.Error(err))
+			ni, err := GetInode(dirfd)
+			if err != nil {
+				c.SetError(err)
+				return
+			}
+			c.SetError(fillFileStat(stat, ni))
+			return
 		}
-
+	}
+	path, err := resolve(dirfd, name)
+	if err != nil {
+		c.SetError(err)
 		return
 	}
-	stat.Mode = uint32(info.Mode())
-	stat.Mtim.Sec = int64(info.ModTime().Unix())
-	stat.Size = info.Size()
-	c.SetRet(0)
-
-}
-
-func sysLseek(c *isyscall.Request) {
-	fd := c.Arg(0)
-	offset := c.Arg(1)
-	whence := c.Arg(2)
-
-	_ = offset
-	_ = whence
-
-	_, err := GetInode(int(fd))
+	var info os.FileInfo
+	if flags&unix.AT_SYMLINK_NOFOLLOW != 0 {
+		info, _, err = Root.LstatIfPossible(path)
+	} else {
+		info, err = Root.Stat(path)
+	}
 	if err != nil {
-		c.SetRet(isyscall.Error(err))
+		c.SetErrorNO(errno(err))
 		return
 	}
+	fillStat(stat, path, info)
 	c.SetRet(0)
 }
 
 func sysRandom(call *isyscall.Request) {
 	p, n := call.Arg(0), call.Arg(1)
 	buf := sys.UnsafeBuffer(p, int(n))
-	rand.Read(buf)
-	call.SetRet(n)
+	ret, err := random{}.Read(buf)
+	if err != nil {
+		call.SetRet(isyscall.Error(err))
+		return
+	}
+	call.SetRet(uintptr(ret))
 }
 
 func cstring(ptr uintptr) string {
@@ -344,6 +706,9 @@ func vfsInit() {
 	AllocFileNode(NewFile(nil, nil, nil))
 
 	etcInit()
+	devInit()
+	procInit()
+	initrdInit()
 }
 
 func sysInit() {
@@ -354,9 +719,26 @@ func sysInit() {
 	isyscall.Register(syscall.SYS_FSTAT, fscall(syscall.SYS_FSTAT))
 	isyscall.Register(syscall.SYS_IOCTL, fscall(syscall.SYS_IOCTL))
 	isyscall.Register(syscall.SYS_FCNTL, sysFcntl)
+	isyscall.Register(syscall.SYS_DUP, sysDup)
+	isyscall.Register(syscall.SYS_DUP2, sysDup)
+	isyscall.Register(syscall.SYS_DUP3, sysDup)
+	isyscall.Register(syscall.SYS_PIPE2, sysPipe2)
 	isyscall.Register(syscall.SYS_NEWFSTATAT, sysFstatat64)
-	isyscall.Register(syscall.SYS_LSEEK, sysLseek)
+	isyscall.Register(syscall.SYS_LSEEK, fscall(syscall.SYS_LSEEK))
+	isyscall.Register(syscall.SYS_PREAD64, fscall(syscall.SYS_PREAD64))
+	isyscall.Register(syscall.SYS_PWRITE64, fscall(syscall.SYS_PWRITE64))
+	isyscall.Register(syscall.SYS_READV, fscall(syscall.SYS_READV))
+	isyscall.Register(syscall.SYS_WRITEV, fscall(syscall.SYS_WRITEV))
+	isyscall.Register(unix.SYS_GETDENTS64, fscall(unix.SYS_GETDENTS64))
+	isyscall.Register(syscall.SYS_MKDIRAT, sysMkdirat)
+	isyscall.Register(syscall.SYS_UNLINKAT, sysUnlinkat)
+	isyscall.Register(syscall.SYS_RENAMEAT, sysRenameat)
+	isyscall.Register(unix.SYS_RENAMEAT2, sysRenameat)
+	isyscall.Register(syscall.SYS_CHDIR, sysChdir)
+	isyscall.Register(syscall.SYS_FCHDIR, sysFchdir)
+	isyscall.Register(syscall.SYS_GETCWD, sysGetcwd)
 	isyscall.Register(syscall.SYS_UNAME, sysUname)
+	isyscall.Register(syscall.SYS_SETHOSTNAME, sysSethostname)
 	isyscall.Register(355, sysRandom)
 }
 
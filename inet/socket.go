@@ -42,7 +42,12 @@ func sysSocket(c *isyscall.Request) {
 		return
 	}
 
-	sfile := allocSockFile(ep, wq)
+	sfile := allocSockFile(ep, wq, typ)
+	if sfile == nil {
+		ep.Close()
+		c.SetErrorNO(syscall.EMFILE)
+		return
+	}
 	c.SetRet(uintptr(sfile.fd))
 
 }
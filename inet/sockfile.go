@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"net"
+	"sync/atomic"
 	"syscall"
 	"time"
 	"unsafe"
@@ -14,29 +15,68 @@ import (
 	"github.com/icexin/eggos/log"
 )
 
-//go:linkname evnotify github.com/icexin/eggos/kernel.epollNotify
-func evnotify(fd, events uintptr)
-
 type sockFile struct {
 	fd int
+	// the events go to every fd dup'ed from fd
+	ni *fs.Inode
 	ep tcpip.Endpoint
 	wq *waiter.Queue
+
+	// set by O_NONBLOCK, a blocking call waits on ready after EAGAIN
+	nonblock int32
+	ready    chan struct{}
 }
 
-func allocSockFile(ep tcpip.Endpoint, wq *waiter.Queue) *sockFile {
+// allocSockFile honours the SOCK_NONBLOCK of flags, it returns nil if there are too many open files
+func allocSockFile(ep tcpip.Endpoint, wq *waiter.Queue, flags uintptr) *sockFile {
 	fd, ni := fs.AllocInode()
+	if fd < 0 {
+		return nil
+	}
 
 	sfile := &sockFile{
-		fd: fd,
-		ep: ep,
-		wq: wq,
+		fd:    fd,
+		ni:    ni,
+		ep:    ep,
+		wq:    wq,
+		ready: make(chan struct{}, 1),
 	}
 	sfile.setupEvent()
 
 	ni.File = sfile
+	ni.SetNonblock(flags&syscall.SOCK_NONBLOCK != 0)
 	return sfile
 }
 
+// IsSocket makes fstat report S_IFSOCK
+func (s *sockFile) IsSocket() bool {
+	return true
+}
+
+func (s *sockFile) SetNonblock(nonblock bool) {
+	var v int32
+	if nonblock {
+		v = 1
+	}
+	atomic.StoreInt32(&s.nonblock, v)
+}
+
+func (s *sockFile) Nonblock() bool {
+	return atomic.LoadInt32(&s.nonblock) != 0
+}
+
+// block calls fn again after every event until it doesn't return EAGAIN,
+// or the socket is non-blocking.
+func (s *sockFile) block(fn func() (int, error)) (int, error) {
+	for {
+		n, err := fn()
+		if err != syscall.EAGAIN || s.Nonblock() {
+			return n, err
+		}
+		<-s.ready
+	}
+}
+
 func findSockFile(fd uintptr) (*sockFile, error) {
 	ni, err := fs.GetInode(int(fd))
 	if err != nil {
@@ -50,6 +90,10 @@ func findSockFile(fd uintptr) (*sockFile, error) {
 }
 
 func (s *sockFile) Read(p []byte) (int, error) {
+	return s.block(func() (int, error) { return s.read(p) })
+}
+
+func (s *sockFile) read(p []byte) (int, error) {
 	var terr tcpip.Error
 	var result tcpip.ReadResult
 
@@ -74,6 +118,10 @@ func (s *sockFile) Read(p []byte) (int, error) {
 }
 
 func (s *sockFile) Write(p []byte) (int, error) {
+	return s.block(func() (int, error) { return s.write(p) })
+}
+
+func (s *sockFile) write(p []byte) (int, error) {
 	n, terr := s.ep.Write(bytes.NewBuffer(p), tcpip.WriteOptions{})
 	if n != 0 {
 		return int(n), nil
@@ -113,8 +161,11 @@ func (s *sockFile) stopEvent() {
 
 func (s *sockFile) evcallback(e *waiter.Entry, mask waiter.EventMask) {
 	// log.Infof("ev:%x fd:%d", mask, s.fd)
-	// syscall.Syscall(kernel.SYS_EPOLL_NOTIFY, uintptr(s.fd), uintptr(mask.ToLinux()), 0)
-	evnotify(uintptr(s.fd), uintptr(mask.ToLinux()))
+	s.ni.Notify(uint32(mask.ToLinux()))
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
 }
 
 func (s *sockFile) Bind(uaddr, uaddrlen uintptr) error {
@@ -149,7 +200,13 @@ func (s *sockFile) Connect(uaddr, uaddrlen uintptr) error {
 	}
 	err := s.ep.Connect(addr)
 	if _, ok := err.(*tcpip.ErrConnectStarted); ok {
-		return syscall.EINPROGRESS
+		if s.Nonblock() {
+			return syscall.EINPROGRESS
+		}
+		for s.ep.Readiness(waiter.EventOut) == 0 {
+			<-s.ready
+		}
+		err = s.ep.LastError()
 	}
 	if err != nil {
 		log.Infof("[socket] connect error:%s", err)
@@ -172,14 +229,23 @@ func (s *sockFile) Accept4(uaddr, uaddrlen, flag uintptr) (int, error) {
 		return 0, syscall.EINVAL
 	}
 	saddr = (*tcpip.SockAddrInet)(unsafe.Pointer(uaddr))
-	newep, wq, err := s.ep.Accept(nil)
-	switch err.(type) {
-	case nil:
-	case *tcpip.ErrWouldBlock:
-		return 0, syscall.EAGAIN
-	default:
-		log.Infof("[socket] accept error:%s", err)
-		return 0, e(err)
+	var newep tcpip.Endpoint
+	var wq *waiter.Queue
+	_, serr := s.block(func() (int, error) {
+		var err tcpip.Error
+		newep, wq, err = s.ep.Accept(nil)
+		switch err.(type) {
+		case nil:
+			return 0, nil
+		case *tcpip.ErrWouldBlock:
+			return 0, syscall.EAGAIN
+		default:
+			log.Infof("[socket] accept error:%s", err)
+			return 0, e(err)
+		}
+	})
+	if serr != nil {
+		return 0, serr
 	}
 
 	newaddr, err := newep.GetRemoteAddress()
@@ -190,7 +256,11 @@ func (s *sockFile) Accept4(uaddr, uaddrlen, flag uintptr) (int, error) {
 	saddr.Family = syscall.AF_INET
 	saddr.Port = htons(newaddr.Port)
 	copy(saddr.Addr[:], newaddr.Addr)
-	sfile := allocSockFile(newep, wq)
+	sfile := allocSockFile(newep, wq, flag)
+	if sfile == nil {
+		newep.Close()
+		return 0, syscall.EMFILE
+	}
 	return sfile.fd, nil
 }
 
@@ -0,0 +1,52 @@
+package kernel
+
+import "math/bits"
+
+// chacha20 block function, see RFC 8439
+
+const (
+	_CHACHA_C0 = 0x61707865
+	_CHACHA_C1 = 0x3320646e
+	_CHACHA_C2 = 0x79622d32
+	_CHACHA_C3 = 0x6b206574
+)
+
+//go:nosplit
+func chachaQuarterRound(a, b, c, d uint32) (uint32, uint32, uint32, uint32) {
+	a += b
+	d ^= a
+	d = bits.RotateLeft32(d, 16)
+	c += d
+	b ^= c
+	b = bits.RotateLeft32(b, 12)
+	a += b
+	d ^= a
+	d = bits.RotateLeft32(d, 8)
+	c += d
+	b ^= c
+	b = bits.RotateLeft32(b, 7)
+	return a, b, c, d
+}
+
+// chachaBlock computes the 20 rounds block of in and stores it in out,
+// in and out may be the same.
+//
+//go:nosplit
+func chachaBlock(out, in *[16]uint32) {
+	x := *in
+	for i := 0; i < 10; i++ {
+		// column rounds
+		x[0], x[4], x[8], x[12] = chachaQuarterRound(x[0], x[4], x[8], x[12])
+		x[1], x[5], x[9], x[13] = chachaQuarterRound(x[1], x[5], x[9], x[13])
+		x[2], x[6], x[10], x[14] = chachaQuarterRound(x[2], x[6], x[10], x[14])
+		x[3], x[7], x[11], x[15] = chachaQuarterRound(x[3], x[7], x[11], x[15])
+		// diagonal rounds
+		x[0], x[5], x[10], x[15] = chachaQuarterRound(x[0], x[5], x[10], x[15])
+		x[1], x[6], x[11], x[12] = chachaQuarterRound(x[1], x[6], x[11], x[12])
+		x[2], x[7], x[8], x[13] = chachaQuarterRound(x[2], x[7], x[8], x[13])
+		x[3], x[4], x[9], x[14] = chachaQuarterRound(x[3], x[4], x[9], x[14])
+	}
+	for i := range x {
+		out[i] = x[i] + in[i]
+	}
+}
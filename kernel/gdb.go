@@ -0,0 +1,717 @@
+package kernel
+
+import (
+	"unsafe"
+
+	"github.com/banditmoscow1337/spos/drivers/uart"
+	"github.com/banditmoscow1337/spos/kernel/mm"
+	"github.com/banditmoscow1337/spos/kernel/sys"
+	"github.com/banditmoscow1337/spos/kernel/trap"
+	"github.com/banditmoscow1337/spos/log"
+)
+
+// A gdb remote serial protocol stub on COM2.
+// The stub runs in the #BP and #DB handlers with the kernel lock held,
+// it polls the serial port until gdb resumes the target.
+// Other cpus are not stopped, they spin on the kernel lock when they trap.
+
+const (
+	_TRAP_DEBUG      = 1
+	_TRAP_BREAKPOINT = 3
+
+	_FLAGS_TF = 0x100
+
+	_GDB_BAUD       = 115200
+	_GDB_PACKET_MAX = 4096
+	_GDB_MAX_BREAKS = 64
+
+	_GDB_SIGTRAP = 5
+
+	// registers in the trap frame, the segment registers are read-only
+	_GDB_NREGS     = 18
+	_GDB_REG_FLAGS = 17
+
+	_INSN_INT3 = 0xcc
+)
+
+type gdbBreak struct {
+	addr  uintptr
+	saved byte
+	used  bool
+}
+
+var (
+	gdbEnabled bool
+	gdbPort    = uart.COM2
+
+	gdbIn  [_GDB_PACKET_MAX]byte
+	gdbOut [_GDB_PACKET_MAX]byte
+	gdbLen int
+
+	gdbBreaks [_GDB_MAX_BREAKS]gdbBreak
+
+	// the thread whose registers are accessed by g/G, 0 means the trapped one
+	gdbThread int
+	// the trap frame of the trapped thread
+	gdbTf *trapFrame
+)
+
+const hexdigits = "0123456789abcdef"
+
+//go:nosplit
+func unhex(ch byte) int {
+	switch {
+	case ch >= '0' && ch <= '9':
+		return int(ch - '0')
+	case ch >= 'a' && ch <= 'f':
+		return int(ch-'a') + 10
+	case ch >= 'A' && ch <= 'F':
+		return int(ch-'A') + 10
+	}
+	return -1
+}
+
+// gdbParseHex parses a hex number at buf[*pos], it stops at the first non hex char
+//
+//go:nosplit
+func gdbParseHex(buf []byte, pos *int) (uintptr, bool) {
+	var v uintptr
+	i := *pos
+	for ; i < len(buf); i++ {
+		d := unhex(buf[i])
+		if d < 0 {
+			break
+		}
+		v = v<<4 | uintptr(d)
+	}
+	ok := i > *pos
+	*pos = i
+	return v, ok
+}
+
+//go:nosplit
+func gdbPutByte(b byte) {
+	if gdbLen+2 > len(gdbOut) {
+		return
+	}
+	gdbOut[gdbLen] = hexdigits[b>>4]
+	gdbOut[gdbLen+1] = hexdigits[b&0xf]
+	gdbLen += 2
+}
+
+//go:nosplit
+func gdbPutStr(s string) {
+	for i := 0; i < len(s) && gdbLen < len(gdbOut); i++ {
+		gdbOut[gdbLen] = s[i]
+		gdbLen++
+	}
+}
+
+// gdbPutHex puts v as a big endian hex number, used by thread ids
+//
+//go:nosplit
+func gdbPutHex(v uintptr) {
+	started := false
+	for shift := 60; shift >= 0; shift -= 4 {
+		d := (v >> uint(shift)) & 0xf
+		if d == 0 && !started && shift != 0 {
+			continue
+		}
+		started = true
+		gdbPutStr(hexdigits[d : d+1])
+	}
+}
+
+// gdbPutReg puts the size bytes of v in target (little endian) order
+//
+//go:nosplit
+func gdbPutReg(v uintptr, size int) {
+	for i := 0; i < size; i++ {
+		gdbPutByte(byte(v >> (8 * i)))
+	}
+}
+
+// gdbRecv reads a packet into gdbIn, the checksum is verified and acked
+//
+//go:nosplit
+func gdbRecv() []byte {
+	for {
+		for gdbGetc() != '$' {
+		}
+		n := 0
+		var sum byte
+		ch := gdbGetc()
+		for ch != '#' {
+			if n < len(gdbIn) {
+				gdbIn[n] = ch
+				n++
+			}
+			sum += ch
+			ch = gdbGetc()
+		}
+		hi := unhex(gdbGetc())
+		lo := unhex(gdbGetc())
+		if hi < 0 || lo < 0 || byte(hi<<4|lo) != sum {
+			gdbPort.Putc('-')
+			continue
+		}
+		gdbPort.Putc('+')
+		return gdbIn[:n]
+	}
+}
+
+// gdbSend sends the packet in gdbOut until gdb acks it
+//
+//go:nosplit
+func gdbSend() {
+	for {
+		var sum byte
+		gdbPort.Putc('$')
+		for i := 0; i < gdbLen; i++ {
+			gdbPort.Putc(gdbOut[i])
+			sum += gdbOut[i]
+		}
+		gdbPort.Putc('#')
+		gdbPort.Putc(hexdigits[sum>>4])
+		gdbPort.Putc(hexdigits[sum&0xf])
+		if gdbGetc() == '+' {
+			return
+		}
+	}
+}
+
+//go:nosplit
+func gdbGetc() byte {
+	for {
+		ch := gdbPort.Getc()
+		if ch >= 0 {
+			return byte(ch)
+		}
+	}
+}
+
+//go:nosplit
+func gdbReply(s string) {
+	gdbLen = 0
+	gdbPutStr(s)
+	gdbSend()
+}
+
+// gdbSelected returns the trap frame of the thread selected by Hg
+//
+//go:nosplit
+func gdbSelected() *trapFrame {
+	if gdbThread <= 0 {
+		return gdbTf
+	}
+	t := getThread(gdbThread - 1)
+	if t == nil || t.tf == nil {
+		return gdbTf
+	}
+	return t.tf
+}
+
+// gdbReg returns the register i in the order of the amd64 g packet,
+// rax to r15, rip and eflags.
+//
+//go:nosplit
+func gdbReg(tf *trapFrame, i int) *uintptr {
+	switch i {
+	case 0:
+		return &tf.AX
+	case 1:
+		return &tf.BX
+	case 2:
+		return &tf.CX
+	case 3:
+		return &tf.DX
+	case 4:
+		return &tf.SI
+	case 5:
+		return &tf.DI
+	case 6:
+		return &tf.BP
+	case 7:
+		return &tf.SP
+	case 8:
+		return &tf.R8
+	case 9:
+		return &tf.R9
+	case 10:
+		return &tf.R10
+	case 11:
+		return &tf.R11
+	case 12:
+		return &tf.R12
+	case 13:
+		return &tf.R13
+	case 14:
+		return &tf.R14
+	case 15:
+		return &tf.R15
+	case 16:
+		return &tf.IP
+	}
+	return &tf.FLAGS
+}
+
+//go:nosplit
+func gdbReadRegs() {
+	tf := gdbSelected()
+	gdbLen = 0
+	for i := 0; i < _GDB_NREGS; i++ {
+		gdbPutReg(*gdbReg(tf, i), gdbRegSize(i))
+	}
+	// cs, ss, ds, es, fs, gs
+	gdbPutReg(tf.CS, 4)
+	gdbPutReg(tf.SS, 4)
+	for i := 0; i < 4; i++ {
+		gdbPutReg(tf.SS, 4)
+	}
+	gdbSend()
+}
+
+// gdbRegSize returns the size of the register i, eflags is 32 bits
+//
+//go:nosplit
+func gdbRegSize(i int) int {
+	if i == _GDB_REG_FLAGS {
+		return 4
+	}
+	return 8
+}
+
+//go:nosplit
+func gdbWriteRegs(pkt []byte) {
+	tf := gdbSelected()
+	pos := 0
+	for i := 0; i < _GDB_NREGS; i++ {
+		size := gdbRegSize(i)
+		if pos+size*2 > len(pkt) {
+			break
+		}
+		var v uintptr
+		for j := 0; j < size; j++ {
+			hi, lo := unhex(pkt[pos]), unhex(pkt[pos+1])
+			if hi < 0 || lo < 0 {
+				gdbReply("E01")
+				return
+			}
+			v |= uintptr(hi<<4|lo) << (8 * j)
+			pos += 2
+		}
+		if i == _GDB_REG_FLAGS {
+			// only the arithmetic flags and tf can be changed
+			v = tf.FLAGS&^0xfd5 | v&0xfd5
+		}
+		*gdbReg(tf, i) = v
+	}
+	gdbReply("OK")
+}
+
+// gdbMemOK reports whether [addr, addr+n) is mapped
+//
+//go:nosplit
+func gdbMemOK(addr, n uintptr) bool {
+	if n == 0 {
+		return true
+	}
+	if addr+n < addr {
+		return false
+	}
+	for p := addr &^ (mm.PGSIZE - 1); p < addr+n; p += mm.PGSIZE {
+		if !mm.Mapped(p) {
+			return false
+		}
+	}
+	return true
+}
+
+// gdbParseRange parses "addr,length" at pkt[pos:]
+//
+//go:nosplit
+func gdbParseRange(pkt []byte, pos *int) (uintptr, uintptr, bool) {
+	addr, ok := gdbParseHex(pkt, pos)
+	if !ok || *pos >= len(pkt) || pkt[*pos] != ',' {
+		return 0, 0, false
+	}
+	*pos++
+	n, ok := gdbParseHex(pkt, pos)
+	return addr, n, ok
+}
+
+//go:nosplit
+func gdbReadMem(pkt []byte) {
+	pos := 1
+	addr, n, ok := gdbParseRange(pkt, &pos)
+	if !ok || n*2 > uintptr(len(gdbOut)) {
+		gdbReply("E01")
+		return
+	}
+	if !gdbMemOK(addr, n) {
+		gdbReply("E14")
+		return
+	}
+	gdbLen = 0
+	for i := uintptr(0); i < n; i++ {
+		gdbPutByte(*(*byte)(unsafe.Pointer(addr + i)))
+	}
+	gdbSend()
+}
+
+//go:nosplit
+func gdbWriteMem(pkt []byte) {
+	pos := 1
+	addr, n, ok := gdbParseRange(pkt, &pos)
+	if !ok || pos >= len(pkt) || pkt[pos] != ':' || uintptr(len(pkt)-pos-1) < n*2 {
+		gdbReply("E01")
+		return
+	}
+	pos++
+	if !gdbMemOK(addr, n) {
+		gdbReply("E14")
+		return
+	}
+	for i := uintptr(0); i < n; i++ {
+		hi, lo := unhex(pkt[pos]), unhex(pkt[pos+1])
+		if hi < 0 || lo < 0 {
+			gdbReply("E01")
+			return
+		}
+		gdbPoke(addr+i, byte(hi<<4|lo))
+		pos += 2
+	}
+	gdbReply("OK")
+}
+
+// gdbPoke writes b at addr, read-only pages like the kernel text
+// are made writable for the write.
+//
+//go:nosplit
+func gdbPoke(addr uintptr, b byte) {
+	perm, _ := mm.Perm(addr)
+	if perm&mm.PTE_W != 0 {
+		*(*byte)(unsafe.Pointer(addr)) = b
+		return
+	}
+	mm.Protect(addr, 1, perm|mm.PTE_W)
+	*(*byte)(unsafe.Pointer(addr)) = b
+	mm.Protect(addr, 1, perm)
+}
+
+//go:nosplit
+func gdbFindBreak(addr uintptr) *gdbBreak {
+	for i := range gdbBreaks {
+		b := &gdbBreaks[i]
+		if b.used && b.addr == addr {
+			return b
+		}
+	}
+	return nil
+}
+
+// gdbBreakpoint handles Z0 and z0, only software breakpoints are supported
+//
+//go:nosplit
+func gdbBreakpoint(pkt []byte) {
+	insert := pkt[0] == 'Z'
+	if len(pkt) < 3 || pkt[1] != '0' || pkt[2] != ',' {
+		gdbReply("")
+		return
+	}
+	pos := 3
+	addr, ok := gdbParseHex(pkt, &pos)
+	if !ok {
+		gdbReply("E01")
+		return
+	}
+	b := gdbFindBreak(addr)
+	if !insert {
+		if b != nil {
+			gdbPoke(addr, b.saved)
+			b.used = false
+		}
+		gdbReply("OK")
+		return
+	}
+	if b != nil {
+		gdbReply("OK")
+		return
+	}
+	if !gdbMemOK(addr, 1) {
+		gdbReply("E14")
+		return
+	}
+	for i := range gdbBreaks {
+		b = &gdbBreaks[i]
+		if b.used {
+			continue
+		}
+		b.addr = addr
+		b.saved = *(*byte)(unsafe.Pointer(addr))
+		b.used = true
+		gdbPoke(addr, _INSN_INT3)
+		gdbReply("OK")
+		return
+	}
+	gdbReply("E28")
+}
+
+//go:nosplit
+func gdbClearBreaks() {
+	for i := range gdbBreaks {
+		b := &gdbBreaks[i]
+		if b.used {
+			gdbPoke(b.addr, b.saved)
+			b.used = false
+		}
+	}
+}
+
+// gdbThreadID returns the gdb thread id of t, gdb reserves 0 and -1
+//
+//go:nosplit
+func gdbThreadID(t *Thread) uintptr {
+	return uintptr(t.id + 1)
+}
+
+//go:nosplit
+func gdbThreadList() {
+	gdbLen = 0
+	gdbPutStr("m")
+	first := true
+	for i := 0; i < nthreads; i++ {
+		t := threads[i].ptr()
+		if t == nil || t.state == UNUSED {
+			continue
+		}
+		if !first {
+			gdbPutStr(",")
+		}
+		first = false
+		gdbPutHex(gdbThreadID(t))
+	}
+	gdbSend()
+}
+
+//go:nosplit
+func gdbThreadExtraInfo(pkt []byte) {
+	pos := len("qThreadExtraInfo,")
+	id, ok := gdbParseHex(pkt, &pos)
+	t := getThread(int(id) - 1)
+	if !ok || t == nil {
+		gdbReply("E01")
+		return
+	}
+	gdbLen = 0
+	if t.state < len(threadStateNames) {
+		s := threadStateNames[t.state]
+		for i := 0; i < len(s); i++ {
+			gdbPutByte(s[i])
+		}
+	}
+	gdbSend()
+}
+
+//go:nosplit
+func gdbHasPrefix(pkt []byte, prefix string) bool {
+	if len(pkt) < len(prefix) {
+		return false
+	}
+	for i := 0; i < len(prefix); i++ {
+		if pkt[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+//go:nosplit
+func gdbQuery(pkt []byte) {
+	switch {
+	case gdbHasPrefix(pkt, "qSupported"):
+		gdbReply("PacketSize=1000;swbreak+")
+	case gdbHasPrefix(pkt, "qAttached"):
+		gdbReply("1")
+	case gdbHasPrefix(pkt, "qC"):
+		gdbLen = 0
+		gdbPutStr("QC")
+		gdbPutHex(gdbThreadID(Mythread()))
+		gdbSend()
+	case gdbHasPrefix(pkt, "qfThreadInfo"):
+		gdbThreadList()
+	case gdbHasPrefix(pkt, "qsThreadInfo"):
+		gdbReply("l")
+	case gdbHasPrefix(pkt, "qThreadExtraInfo,"):
+		gdbThreadExtraInfo(pkt)
+	default:
+		gdbReply("")
+	}
+}
+
+// gdbSetThread handles Hg and Hc, only the thread of g/G can be selected,
+// the trapped thread is always the one resumed.
+//
+//go:nosplit
+func gdbSetThread(pkt []byte) {
+	if len(pkt) < 3 {
+		gdbReply("E01")
+		return
+	}
+	if pkt[1] != 'g' {
+		gdbReply("OK")
+		return
+	}
+	// 0 is any thread and -1 all threads
+	if pkt[2] == '-' {
+		gdbThread = 0
+		gdbReply("OK")
+		return
+	}
+	pos := 2
+	id, _ := gdbParseHex(pkt, &pos)
+	if id != 0 && getThread(int(id)-1) == nil {
+		gdbReply("E01")
+		return
+	}
+	gdbThread = int(id)
+	gdbReply("OK")
+}
+
+//go:nosplit
+func gdbThreadAlive(pkt []byte) {
+	pos := 1
+	id, ok := gdbParseHex(pkt, &pos)
+	t := getThread(int(id) - 1)
+	if !ok || t == nil || t.state == UNUSED || t.state == EXIT {
+		gdbReply("E01")
+		return
+	}
+	gdbReply("OK")
+}
+
+//go:nosplit
+func gdbStopReply(swbreak bool) {
+	gdbLen = 0
+	gdbPutStr("T")
+	gdbPutByte(_GDB_SIGTRAP)
+	gdbPutStr("thread:")
+	gdbPutHex(gdbThreadID(Mythread()))
+	gdbPutStr(";")
+	if swbreak {
+		gdbPutStr("swbreak:;")
+	}
+	gdbSend()
+}
+
+// gdbResume handles c and s, an optional address sets the ip
+//
+//go:nosplit
+func gdbResume(pkt []byte, step bool) {
+	tf := gdbTf
+	pos := 1
+	if addr, ok := gdbParseHex(pkt, &pos); ok {
+		tf.IP = addr
+	}
+	if step {
+		tf.FLAGS |= _FLAGS_TF
+	} else {
+		tf.FLAGS &^= _FLAGS_TF
+	}
+}
+
+// gdbLoop serves gdb until the target is resumed
+//
+//go:nosplit
+func gdbLoop(swbreak bool) {
+	gdbStopReply(swbreak)
+	for {
+		pkt := gdbRecv()
+		if len(pkt) == 0 {
+			gdbReply("")
+			continue
+		}
+		switch pkt[0] {
+		case '?':
+			gdbStopReply(false)
+		case 'g':
+			gdbReadRegs()
+		case 'G':
+			gdbWriteRegs(pkt[1:])
+		case 'm':
+			gdbReadMem(pkt)
+		case 'M':
+			gdbWriteMem(pkt)
+		case 'Z', 'z':
+			gdbBreakpoint(pkt)
+		case 'H':
+			gdbSetThread(pkt)
+		case 'T':
+			gdbThreadAlive(pkt)
+		case 'q':
+			gdbQuery(pkt)
+		case 'c':
+			gdbResume(pkt, false)
+			return
+		case 's':
+			gdbResume(pkt, true)
+			return
+		case 'D':
+			gdbClearBreaks()
+			gdbTf.FLAGS &^= _FLAGS_TF
+			gdbReply("OK")
+			return
+		case 'k':
+			gdbClearBreaks()
+			gdbTf.FLAGS &^= _FLAGS_TF
+			return
+		default:
+			gdbReply("")
+		}
+	}
+}
+
+//go:nosplit
+func gdbTrap() {
+	tf := Mythread().tf
+	// avoid write barrier
+	*(*uintptr)(unsafe.Pointer(&gdbTf)) = uintptr(unsafe.Pointer(tf))
+	gdbThread = 0
+
+	swbreak := false
+	switch tf.Trapno {
+	case _TRAP_BREAKPOINT:
+		// the ip is after the int3, rewind it if the breakpoint is ours
+		if gdbFindBreak(tf.IP-1) != nil {
+			tf.IP--
+			swbreak = true
+		}
+	case _TRAP_DEBUG:
+		tf.FLAGS &^= _FLAGS_TF
+	}
+	gdbLoop(swbreak)
+	*(*uintptr)(unsafe.Pointer(&gdbTf)) = 0
+}
+
+// gdbPanic lets gdb inspect the kernel before the panic exits
+//
+//go:nosplit
+func gdbPanic(tf *trapFrame) {
+	*(*uintptr)(unsafe.Pointer(&gdbTf)) = uintptr(unsafe.Pointer(tf))
+	gdbThread = 0
+	gdbLoop(false)
+}
+
+// gdbInit enables the stub, the #BP and #DB traps are routed to gdb
+// instead of panic.
+//
+//go:nosplit
+func gdbInit() {
+	gdbPort.Setup(_GDB_BAUD, false)
+	trap.Register(_TRAP_DEBUG, gdbTrap)
+	trap.Register(_TRAP_BREAKPOINT, gdbTrap)
+	// int3 comes from user mode
+	setIdtDesc(&idt[_TRAP_BREAKPOINT], sys.FuncPC(vectors[_TRAP_BREAKPOINT]), segDplUser)
+	gdbEnabled = true
+	log.PrintStr("[gdb] stub on com2\n")
+}
@@ -1,11 +1,25 @@
 package kernel
 
-import "github.com/banditmoscow1337/spos/drivers/clock"
+import (
+	"runtime"
+
+	"github.com/banditmoscow1337/spos/bootcfg"
+	"github.com/banditmoscow1337/spos/drivers/clock"
+)
 
 // called when go runtime init done
 func Init() {
+	if n := bootcfg.Get().MaxThreads; n != 0 {
+		SetThreadLimit(n)
+	}
 	clockTimeInit()
-	idleInit()
+	// spos_GDB=1 enables the gdb stub, spos_GDB=wait also breaks here
+	if mode := bootcfg.Get().GDB; mode != "" {
+		gdbInit()
+		if mode == "wait" {
+			runtime.Breakpoint()
+		}
+	}
 	go runTrapThread()
 	go runSyscallThread()
 	bootstrapDone = true
@@ -14,5 +28,5 @@ func Init() {
 func clockTimeInit() {
 	t := clock.ReadCmosTime()
 	baseUnixTime = t.Time().Unix()
-	clockBaseCounter = counter
+	clockBaseNano = nanosecond()
 }
@@ -22,6 +22,8 @@ type Request struct {
 	tf *trapFrame
 
 	Lock uintptr
+	// the thread making the call
+	Tid int
 }
 
 //go:nosplit
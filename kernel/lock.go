@@ -39,18 +39,15 @@ func sleeptimeout(addr *uintptr, val uintptr, ts *linux.Timespec) {
 		panic("sleeptimeout: nil ts")
 	}
 	deadline := nanosecond() + int64(ts.Nsec) + int64(ts.Sec)*second
-	// check on every timer intr
-	now := nanosecond()
 	t := Mythread()
-	for now < deadline && *addr == val {
-		t.timerKey = uintptr(unsafe.Pointer(&sleeplock))
+	timerAdd(t, deadline)
+	for nanosecond() < deadline && *addr == val {
 		t.sleepKey = uintptr(unsafe.Pointer(addr))
 		t.state = SLEEPING
 		Sched()
-		t.timerKey = 0
 		t.sleepKey = 0
-		now = nanosecond()
 	}
+	timerDel(t)
 }
 
 //go:nosplit
@@ -69,13 +66,17 @@ func wakeup(lock *uintptr, n int) {
 	limit := uint(n)
 	cnt := uint(0)
 	lockKey := uintptr(unsafe.Pointer(lock))
-	for i := 0; i < _NTHREDS; i++ {
-		t := &threads[i]
-		if (t.sleepKey == lockKey || t.timerKey == lockKey) && cnt < limit {
+	for i := 0; i < nthreads; i++ {
+		t := threads[i].ptr()
+		if t == nil {
+			continue
+		}
+		if t.sleepKey == lockKey && cnt < limit {
 			cnt++
 			t.state = RUNNABLE
 		}
 	}
+	kickIdle(int(cnt))
 }
 
 type note uintptr
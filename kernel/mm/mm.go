@@ -14,17 +14,46 @@ const (
 	// 默认可以使用的物理内存终止地址，如果能从grub那里获取就用grub的
 	DEFAULT_MEMTOP = 256 << 20
 	// 虚拟内存起始地址
-	VMSTART = 1 << 30
+	// the heap window starts at 1 TiB, the physical memory below it is identity mapped.
+	// The allocator returns identity mapped pages, so the memory above is not used.
+	VMSTART = 1 << 40
 
 	PTE_P = 0x001
 	PTE_W = 0x002
 	PTE_U = 0x004
+	// the entry of a page directory maps a large page
+	PTE_PS = 0x080
+	PTE_NX = 1 << 63
+
+	// sizes of the large pages mapped by the level 2 and level 3 entries
+	PGSIZE_2M = 2 << 20
+	PGSIZE_1G = 1 << 30
+
+	// permission of data mappings
+	PERM_DATA = PTE_P | PTE_W | PTE_U | PTE_NX
+	// permission of code mappings
+	PERM_TEXT = PTE_P | PTE_U
+	// permission of read-only data mappings
+	PERM_RODATA = PTE_P | PTE_U | PTE_NX
+
+	// the low memory holds the bios data and the ap trampoline
+	_LOWMEM_TOP = 1 << 20
+
+	_PTE_ADDR_MASK = 0x000ffffffffff000
+	_PTE_PERM_MASK = PTE_P | PTE_W | PTE_U | PTE_NX
 
 	_ENTRY_NUMBER = PGSIZE / sys.PtrSize
 )
 
 var (
 	memtop uintptr
+	// the bytes of available memory above VMSTART
+	droppedMem uint64
+
+	// EFER.NXE is set, PTE_NX is cleared from all permissions if not
+	nxEnabled bool
+	// the cpu supports 1 GiB pages
+	page1GEnabled bool
 
 	kmm = kmmt{voffset: VMSTART}
 	vmm vmmt
@@ -36,6 +65,16 @@ func pageEnable()
 //go:nosplit
 func lcr3(topPage *entryPage)
 
+// enableNX sets EFER.NXE if the cpu supports the no-execute bit
+//
+//go:nosplit
+func enableNX() bool
+
+// hasPage1G reports whether cpuid has the 1 GiB page feature
+//
+//go:nosplit
+func hasPage1G() bool
+
 //go:linkname throw github.com/banditmoscow1337/spos/kernel.throw
 func throw(msg string)
 
@@ -54,23 +93,48 @@ func pageEntryIdx(v uintptr, lvl int) uintptr {
 	return (v >> (12 + (lvl-1)*9)) & (_ENTRY_NUMBER - 1)
 }
 
+// pageSize returns the size mapped by an entry of the level lvl
+//
+//go:nosplit
+func pageSize(lvl int) uintptr {
+	return 1 << (12 + (lvl-1)*9)
+}
+
 //go:notinheap
 type page struct {
 	next *page
 }
 
 type kmmstat struct {
+	// physical pages in use
 	alloc int
+	// physical pages in the free list
+	free int
+	// all physical pages managed by kmm
+	total int
+	// pages used by the page tables
+	pagetables int
+	// pages grown by all Pools and the bytes allocated from them
+	poolPages int
+	poolInuse uintptr
 }
 
 type kmmt struct {
+	// the freed pages, the pages never allocated are in phys
 	freelist *page
-	voffset  uintptr
-	stat     kmmstat
+	phys     physmem
+	// the break of the virtual address, the address below it is either used or in vspace
+	voffset uintptr
+	vspace  vspace
+	stat    kmmstat
 }
 
 //go:nosplit
 func (k *kmmt) sbrk(n uintptr) uintptr {
+	n = pageRoundUp(n)
+	if va := k.vspace.alloc(n); va != 0 {
+		return va
+	}
 	p := k.voffset
 	k.voffset = pageRoundUp(k.voffset + n)
 	if k.voffset < p {
@@ -79,33 +143,81 @@ func (k *kmmt) sbrk(n uintptr) uintptr {
 	return p
 }
 
+// vfree returns the virtual address [va, va+n) allocated by sbrk
+//
+//go:nosplit
+func (k *kmmt) vfree(va, n uintptr) {
+	start := pageRoundDown(va)
+	end := pageRoundUp(va + n)
+	if start < VMSTART || end > k.voffset || start >= end {
+		return
+	}
+	if end != k.voffset {
+		k.vspace.release(start, end)
+		return
+	}
+	// shrink the break, along with the free range under it
+	k.voffset = start
+	if r := k.vspace.last(); r != nil && r.end == k.voffset {
+		k.voffset = r.start
+		k.vspace.free -= r.end - r.start
+		k.vspace.n--
+	}
+}
+
 //go:nosplit
 func (k *kmmt) alloc() uintptr {
+	p := k.tryAlloc()
+	if p == 0 {
+		throw("kmemt.alloc")
+	}
+	return p
+}
+
+// tryAlloc is alloc returning 0 if no page is free
+//
+//go:nosplit
+func (k *kmmt) tryAlloc() uintptr {
 	r := k.freelist
 	if r == nil {
-		throw("kmemt.alloc")
+		p := k.phys.alloc()
+		if p != 0 {
+			k.stat.alloc++
+			k.stat.free--
+		}
+		return p
 	}
 	k.stat.alloc++
-	k.freelist = r.next
+	k.stat.free--
+	// no write barrier, the pages are never in the heap
+	*(*uintptr)(unsafe.Pointer(&k.freelist)) = uintptr(unsafe.Pointer(r.next))
 	return uintptr(unsafe.Pointer(r))
 }
 
+// freeRange adds the physical memory [start, end) to kmm
+//
 //go:nosplit
 func (k *kmmt) freeRange(start, end uintptr) {
-	p := pageRoundUp(start)
-	for ; p+PGSIZE <= end; p += PGSIZE {
-		k.free(p)
-	}
+	n := k.phys.add(start, end)
+	k.stat.total += n
+	k.stat.free += n
 }
 
 //go:nosplit
 func (k *kmmt) free(p uintptr) {
+	k.push(p)
+	k.stat.alloc--
+}
+
+//go:nosplit
+func (k *kmmt) push(p uintptr) {
 	if p%PGSIZE != 0 || p >= memtop {
 		throw("kmemt.free")
 	}
 	r := (*page)(unsafe.Pointer(p))
 	r.next = k.freelist
 	k.freelist = r
+	k.stat.free++
 }
 
 //go:notinheap
@@ -118,9 +230,31 @@ func (p entry) present() bool {
 	return p&PTE_P != 0
 }
 
+// large reports whether the entry of a level 2 or 3 page directory maps a page
+//
+//go:nosplit
+func (p entry) large() bool {
+	return p&PTE_PS != 0
+}
+
 //go:nosplit
 func (p entry) addr() uintptr {
-	return uintptr(p) &^ 0xfff
+	return uintptr(p) & _PTE_ADDR_MASK
+}
+
+//go:nosplit
+func (p entry) perm() uintptr {
+	return uintptr(p) & _PTE_PERM_MASK
+}
+
+// permOf clears the bits not supported by the cpu
+//
+//go:nosplit
+func permOf(perm uintptr) uintptr {
+	if !nxEnabled {
+		perm &^= PTE_NX
+	}
+	return perm
 }
 
 //go:nosplit
@@ -135,15 +269,17 @@ type vmmt struct {
 //go:nosplit
 func (v *vmmt) munmap(va, size uintptr) bool {
 	// println("mumap va=", va, " size=", size)
+	if size == 0 {
+		return false
+	}
 	p := pageRoundDown(va)
 	last := pageRoundDown(va + size - 1)
-	for ; p != last; p += PGSIZE {
-		pte := v.walkpgdir(p, false)
-		if pte == nil {
-			return false
-		}
-		if !pte.present() {
-			return false
+	for ; p <= last; p += PGSIZE {
+		// reserved but never mapped pages are skipped, large pages are
+		// only used by fixed mappings which don't own the memory
+		pte, lvl := v.lookup(p)
+		if pte == nil || !pte.present() || lvl != 1 {
+			continue
 		}
 		kmm.free(pte.addr())
 		*pte = 0
@@ -177,36 +313,161 @@ func (v *vmmt) mmap(va, size, perm uintptr) bool {
 	return true
 }
 
+// protect changes the permission of the present pages in [va, va+size)
+//
+//go:nosplit
+func (v *vmmt) protect(va, size, perm uintptr) bool {
+	p := pageRoundDown(va)
+	last := pageRoundDown(va + size - 1)
+	for p <= last {
+		pte, lvl := v.lookup(p)
+		if pte == nil || !pte.present() {
+			return false
+		}
+		sz := pageSize(lvl)
+		// a large page partially covered is split first
+		if lvl > 1 && (p&(sz-1) != 0 || last-p < sz-PGSIZE) {
+			if !v.split(pte, lvl) {
+				return false
+			}
+			continue
+		}
+		*pte = entry(pte.addr() | uintptr(*pte)&PTE_PS | perm)
+		p += sz
+	}
+	return true
+}
+
 //go:nosplit
 func Sbrk(n uintptr) uintptr {
 	return kmm.sbrk(n)
 }
 
+// Mmap maps fresh pages at va as read-write data, a va of 0 picks a free address
+//
 //go:nosplit
 func Mmap(va, size uintptr) uintptr {
+	return MmapPerm(va, size, PERM_DATA)
+}
+
+// MmapPerm is Mmap with the page permission perm
+//
+//go:nosplit
+func MmapPerm(va, size, perm uintptr) uintptr {
 	if va == 0 {
 		va = kmm.sbrk(size)
 	}
-	vmm.mmap(va, size, PTE_P|PTE_W|PTE_U)
+	vmm.mmap(va, size, permOf(perm))
 	// flush page table cache
 	lcr3(vmm.topPage)
 	return va
 }
 
+// Protect changes the permission of the mapped range [va, va+size),
+// it returns false if some page is not mapped.
+//
+//go:nosplit
+func Protect(va, size, perm uintptr) bool {
+	ok := vmm.protect(va, size, permOf(perm))
+	lcr3(vmm.topPage)
+	return ok
+}
+
+// Perm returns the permission of the page at va
+//
+//go:nosplit
+func Perm(va uintptr) (uintptr, bool) {
+	// non canonical address
+	if va >= 1<<47 && va < 0xffff800000000000 {
+		return 0, false
+	}
+	pte, _ := vmm.lookup(va)
+	if pte == nil || !pte.present() {
+		return 0, false
+	}
+	return pte.perm(), true
+}
+
+// NXEnabled reports whether the no-execute bit is in use,
+// every cpu must set EFER.NXE before loading the page table.
+//
+//go:nosplit
+func NXEnabled() bool {
+	return nxEnabled
+}
+
+// DroppedMemory returns the bytes of available memory above VMSTART,
+// which are left out by Init.
+//
+//go:nosplit
+func DroppedMemory() uint64 {
+	return droppedMem
+}
+
+// Munmap frees the pages in [va, va+size) and recycles the address range,
+// pages not present are skipped.
+//
 //go:nosplit
 func Munmap(va, size uintptr) bool {
 	ok := vmm.munmap(va, size)
+	if ok {
+		kmm.vfree(va, size)
+	}
 	lcr3(vmm.topPage)
 	return ok
 }
 
+// Fixmap maps [va, va+size) to pa, the pages already mapped to the same
+// address with the same permission are accepted.
+//
 //go:nosplit
 func Fixmap(va, pa, size uintptr) {
-	vmm.fixmap(va, pa, size, PTE_P|PTE_W|PTE_U)
+	vmm.fixmap(va, pa, size, permOf(PERM_DATA))
 	// flush page table cache
 	lcr3(vmm.topPage)
 }
 
+// IdentityMap maps [pa, pa+size) to the same virtual address.
+// Unlike Fixmap, pages already present are left untouched,
+// which is handy for firmware tables and MMIO above memtop.
+//
+//go:nosplit
+func IdentityMap(pa, size uintptr) {
+	p := pageRoundDown(pa)
+	last := pageRoundDown(pa + size - 1)
+	for {
+		if pte, _ := vmm.lookup(p); pte == nil || !pte.present() {
+			pte = vmm.walkpgdir(p, true)
+			if pte == nil {
+				throw("IdentityMap")
+			}
+			*pte = entry(p | permOf(PERM_DATA))
+		}
+		if p == last {
+			break
+		}
+		p += PGSIZE
+	}
+	lcr3(vmm.topPage)
+}
+
+// TopPage returns the physical address of the top level page table,
+// used by application processors to share the same address space.
+//
+//go:nosplit
+func TopPage() uintptr {
+	return uintptr(unsafe.Pointer(vmm.topPage))
+}
+
+// Mapped reports whether va is mapped, it never faults so
+// debuggers can probe an arbitrary address with it.
+//
+//go:nosplit
+func Mapped(va uintptr) bool {
+	_, ok := Perm(va)
+	return ok
+}
+
 //go:nosplit
 func Alloc() uintptr {
 	ptr := kmm.alloc()
@@ -217,78 +478,163 @@ func Alloc() uintptr {
 	return ptr
 }
 
+// Free returns the page allocated by Alloc
+//
+//go:nosplit
+func Free(p uintptr) {
+	kmm.free(p)
+}
+
+// largeLevel returns the level of the largest page mapping va to pa
+// within n bytes, or 1 if no large page fits.
+//
+//go:nosplit
+func largeLevel(va, pa, n uintptr) int {
+	for lvl := 3; lvl > 1; lvl-- {
+		if lvl == 3 && !page1GEnabled {
+			continue
+		}
+		sz := pageSize(lvl)
+		if va&(sz-1) == 0 && pa&(sz-1) == 0 && n >= sz {
+			return lvl
+		}
+	}
+	return 1
+}
+
+// fixmap maps [va, va+size) to pa, it uses large pages where the addresses
+// are aligned and the page directory isn't populated yet.
+//
 //go:nosplit
 func (v *vmmt) fixmap(va, pa, size, perm uintptr) bool {
 	p := pageRoundDown(va)
-	last := pageRoundDown(va + size - 1)
-	for {
-		pte := v.walkpgdir(p, true)
-		if pte == nil {
-			return false
+	end := pageRoundUp(va + size)
+	for p < end {
+		// an identical mapping is kept, the memory is already identity mapped
+		if pte, lvl := v.lookup(p); pte != nil && pte.present() {
+			sz := pageSize(lvl)
+			off := p & (sz - 1)
+			if pte.addr()&^(sz-1)+off != pa || pte.perm() != perm {
+				throw("fixmap remap")
+			}
+			p += sz - off
+			pa += sz - off
+			continue
+		}
+		lvl := largeLevel(p, pa, end-p)
+		var pte *entry
+		for ; lvl >= 1; lvl-- {
+			pte = v.walk(p, lvl, true)
+			if pte == nil {
+				return false
+			}
+			// a table at this level is already there, try a smaller page
+			if lvl == 1 || !pte.present() || pte.large() {
+				break
+			}
 		}
 		if pte.present() {
 			throw("fixmap remap")
 		}
-		*pte = entry(pa | perm)
-		if p == last {
-			break
+		if lvl > 1 {
+			*pte = entry(pa | perm | PTE_PS)
+		} else {
+			*pte = entry(pa | perm)
 		}
-		p += PGSIZE
-		pa += PGSIZE
+		p += pageSize(lvl)
+		pa += pageSize(lvl)
 	}
 	return true
 }
 
+// newTable allocates an empty page table for the entry pe,
+// it returns false if no page is free.
+//
 //go:nosplit
-func (v *vmmt) walkpglvl(pg *entryPage, va uintptr, lvl int, alloc bool) *entry {
-	idx := pageEntryIdx(va, lvl)
-	if int(idx) >= len(pg) {
-		throw("bad page index")
-	}
-	pe := &pg[idx]
-	if lvl == 1 {
-		return pe
+func (v *vmmt) newTable(pe *entry) bool {
+	addr := kmm.tryAlloc()
+	if addr == 0 {
+		return false
 	}
+	kmm.stat.pagetables++
+	sys.Memclr(addr, PGSIZE)
+	*pe = entry(addr | PTE_P | PTE_W | PTE_U)
+	return true
+}
 
-	// find entry
-	if pe.present() {
-		return pe
+// split replaces the large page of the entry pe at the level lvl
+// with a page table mapping the same memory with smaller pages.
+//
+//go:nosplit
+func (v *vmmt) split(pe *entry, lvl int) bool {
+	sz := pageSize(lvl - 1)
+	flags := pe.perm()
+	if lvl-1 > 1 {
+		flags |= PTE_PS
 	}
-	// not found and no alloc
-	if !alloc {
-		return nil
+	base := pe.addr() &^ (pageSize(lvl) - 1)
+	if !v.newTable(pe) {
+		return false
 	}
-	// alloc a page to map entry page
-	addr := kmm.alloc()
-	if addr == 0 {
-		return nil
+	pg := pe.entryPage()
+	for i := range pg {
+		pg[i] = entry(base + uintptr(i)*sz | flags)
+	}
+	return true
+}
+
+// walk returns the entry of va in the page directory of the level lvl, the missing
+// tables are allocated and the large pages above lvl are split if alloc is set.
+//
+//go:nosplit
+func (v *vmmt) walk(va uintptr, lvl int, alloc bool) *entry {
+	pg := v.topPage
+	for i := 4; ; i-- {
+		pe := &pg[pageEntryIdx(va, i)]
+		if i == lvl {
+			return pe
+		}
+		switch {
+		case !pe.present():
+			if !alloc || !v.newTable(pe) {
+				return nil
+			}
+		case pe.large():
+			if !alloc || !v.split(pe, i) {
+				return nil
+			}
+		}
+		pg = pe.entryPage()
 	}
-	sys.Memclr(addr, PGSIZE)
-	// map new page to entry
-	*pe = entry(addr | PTE_P | PTE_W | PTE_U)
-	// log.PrintHex(uintptr(unsafe.Pointer(pg)))
-	// log.PrintStr("@")
-	// log.PrintHex(idx)
-	// log.PrintStr("=")
-	// log.PrintHex(addr)
-	// log.PrintStr("\n")
-	return pe
 }
 
+// walkpgdir returns the last level entry of va
+//
 //go:nosplit
 func (v *vmmt) walkpgdir(va uintptr, alloc bool) *entry {
-	epg := v.topPage
-	var pe *entry
-	for i := 4; i >= 1; i-- {
-		pe = v.walkpglvl(epg, va, i, alloc)
-		if pe == nil {
-			return nil
+	return v.walk(va, 1, alloc)
+}
+
+// lookup returns the entry mapping va and its level, which is above 1 for
+// a large page. It never allocates, nil is returned if a table is missing.
+//
+//go:nosplit
+func (v *vmmt) lookup(va uintptr) (*entry, int) {
+	pg := v.topPage
+	for lvl := 4; ; lvl-- {
+		pe := &pg[pageEntryIdx(va, lvl)]
+		if lvl == 1 || pe.present() && pe.large() && lvl <= 3 {
+			return pe, lvl
 		}
-		epg = pe.entryPage()
+		if !pe.present() {
+			return nil, lvl
+		}
+		pg = pe.entryPage()
 	}
-	return pe
 }
 
+// findMemTop returns the top of the available memory, capped to VMSTART
+//
 //go:nosplit
 func findMemTop() uintptr {
 	if !multiboot.Enabled() {
@@ -300,7 +646,13 @@ func findMemTop() uintptr {
 			continue
 		}
 		ptop := e.Addr + e.Len
+		// the memory overlapping the heap window can't be identity mapped
 		if ptop > VMSTART {
+			start := e.Addr
+			if start < VMSTART {
+				start = VMSTART
+			}
+			droppedMem += ptop - start
 			ptop = VMSTART
 		}
 		if top < uintptr(ptop) {
@@ -313,17 +665,123 @@ func findMemTop() uintptr {
 	return top
 }
 
+// freeRegion adds [start, end) to kmm except the boot modules,
+// which are read after the go runtime is up.
+//
+//go:nosplit
+func freeRegion(start, end uintptr) {
+	mods := multiboot.Modules()
+	for start < end {
+		// the lowest module overlapping [start, end)
+		next, nextEnd := end, end
+		for _, m := range mods {
+			ms, me := pageRoundDown(m.Start), pageRoundUp(m.End)
+			if ms < next && me > start {
+				next, nextEnd = ms, me
+			}
+		}
+		if next > start {
+			kmm.freeRange(start, next)
+		}
+		start = nextEnd
+	}
+}
+
+// freeMemory adds the available memory of the multiboot memory map above MEMSTART
+//
+//go:nosplit
+func freeMemory() {
+	if multiboot.Enabled() {
+		for _, e := range multiboot.BootInfo.MmapEntries() {
+			if e.Type != multiboot.MemoryAvailable {
+				continue
+			}
+			start, end := uintptr(e.Addr), uintptr(e.Addr+e.Len)
+			if start < MEMSTART {
+				start = MEMSTART
+			}
+			if end > memtop {
+				end = memtop
+			}
+			freeRegion(start, end)
+		}
+	}
+	if kmm.stat.total == 0 {
+		kmm.freeRange(MEMSTART, memtop)
+	}
+}
+
+// identityMap maps [start, end) to itself, writable and no-execute
+// except the kernel text in [text, etext).
+//
+//go:nosplit
+func identityMap(start, end, text, etext uintptr) {
+	if text < start {
+		text = start
+	}
+	if etext > end {
+		etext = end
+	}
+	if text >= etext {
+		vmm.fixmap(start, start, end-start, permOf(PERM_DATA))
+		return
+	}
+	vmm.fixmap(start, start, text-start, permOf(PERM_DATA))
+	vmm.fixmap(text, text, etext-text, PTE_P|PTE_W|PTE_U)
+	vmm.fixmap(etext, etext, end-etext, permOf(PERM_DATA))
+}
+
+// identityMapMemory maps the available memory of the multiboot memory map
+// above the low memory, the holes like the pci window are left to the drivers.
+//
+//go:nosplit
+func identityMapMemory(text, etext uintptr) {
+	mapped := false
+	if multiboot.Enabled() {
+		for _, e := range multiboot.BootInfo.MmapEntries() {
+			if e.Type != multiboot.MemoryAvailable {
+				continue
+			}
+			start, end := pageRoundUp(uintptr(e.Addr)), pageRoundDown(uintptr(e.Addr+e.Len))
+			if start < _LOWMEM_TOP {
+				start = _LOWMEM_TOP
+			}
+			if end > memtop {
+				end = memtop
+			}
+			if start >= end {
+				continue
+			}
+			identityMap(start, end, text, etext)
+			mapped = true
+		}
+	}
+	if !mapped {
+		identityMap(_LOWMEM_TOP, memtop, text, etext)
+	}
+}
+
+// Init builds the page table, [text, etext) is the kernel text which
+// must stay executable once NX is on.
+//
 //go:nosplit
-func Init() {
+func Init(text, etext uintptr) {
 	memtop = findMemTop()
+	nxEnabled = enableNX()
+	page1GEnabled = hasPage1G()
 	kmm.voffset = VMSTART
-	kmm.freeRange(MEMSTART, memtop)
+	freeMemory()
 
+	// the pages are allocated from the lowest address, so the page tables
+	// built here are covered by the boot page table of the first 1 GiB,
+	// and the top page is below 4 GiB as the ap trampoline requires.
 	vmm.topPage = (*entryPage)(unsafe.Pointer(kmm.alloc()))
 	sys.Memclr(uintptr(unsafe.Pointer(vmm.topPage)), PGSIZE)
 	// 4096-MEMTOP 用来让微内核访问到所有的地址空间
-	// identity map all phy memory
-	vmm.fixmap(4096, 4096, memtop-4096, PTE_P|PTE_W|PTE_U)
+	// identity map the low memory and the ram, only the low memory and the kernel
+	// text are executable, the kernel protects its image later.
+	vmm.fixmap(4096, 4096, _LOWMEM_TOP-4096, PTE_P|PTE_W|PTE_U)
+	identityMapMemory(pageRoundDown(text), pageRoundUp(etext))
 
 	lcr3(vmm.topPage)
 	pageEnable()
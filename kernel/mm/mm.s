@@ -8,12 +8,34 @@ TEXT ·pageEnable(SB), NOSPLIT, $0-0
 	BTSQ $5, AX
 	MOVQ AX, CR4
 
-	// enable page
+	// enable page and write protect in supervisor mode
 	MOVQ CR0, AX
 	BTSQ $31, AX
+	BTSQ $16, AX
 	MOVQ AX, CR0
 	RET
 
+// enableNX() bool sets EFER.NXE if cpuid reports the NX bit.
+TEXT ·enableNX(SB), NOSPLIT, $0-1
+	MOVL $0x80000000, AX
+	CPUID
+	CMPL AX, $0x80000001
+	JB   nonx
+	MOVL $0x80000001, AX
+	CPUID
+	BTL  $20, DX
+	JCC  nonx
+	MOVL $0xc0000080, CX // EFER
+	RDMSR
+	BTSL $11, AX
+	WRMSR
+	MOVB $1, ret+0(FP)
+	RET
+
+nonx:
+	MOVB $0, ret+0(FP)
+	RET
+
 // lcr3(topPage uint64) sets the CR3 register.
 TEXT ·lcr3(SB), NOSPLIT, $0-8
 	// setup page dir
@@ -21,3 +43,20 @@ TEXT ·lcr3(SB), NOSPLIT, $0-8
 	MOVQ AX, CR3
 	RET
 
+
+// hasPage1G() bool reports the Page1GB bit of cpuid 0x80000001.
+TEXT ·hasPage1G(SB), NOSPLIT, $0-1
+	MOVL $0x80000000, AX
+	CPUID
+	CMPL AX, $0x80000001
+	JB   no1g
+	MOVL $0x80000001, AX
+	CPUID
+	BTL  $26, DX
+	JCC  no1g
+	MOVB $1, ret+0(FP)
+	RET
+
+no1g:
+	MOVB $0, ret+0(FP)
+	RET
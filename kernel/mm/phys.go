@@ -0,0 +1,50 @@
+package mm
+
+const (
+	// the available ranges beyond this in the memory map are ignored
+	_MAX_PHYS_RANGES = 32
+)
+
+// physmem hands out the physical pages never allocated, lowest address first.
+// The pages aren't touched before being allocated, so memory not covered by
+// the page table of the boot loader can be added before paging is set up.
+type physmem struct {
+	// sorted by the descending address, the lowest range is the last
+	ranges [_MAX_PHYS_RANGES]vrange
+	n      int
+}
+
+// add inserts the pages in [start, end), it returns the number of pages added
+//
+//go:nosplit
+func (m *physmem) add(start, end uintptr) int {
+	start = pageRoundUp(start)
+	end = pageRoundDown(end)
+	if start >= end || m.n == len(m.ranges) {
+		return 0
+	}
+	i := m.n
+	for i > 0 && m.ranges[i-1].start < start {
+		m.ranges[i] = m.ranges[i-1]
+		i--
+	}
+	m.ranges[i] = vrange{start, end}
+	m.n++
+	return int((end - start) / PGSIZE)
+}
+
+// alloc returns the lowest page not allocated yet, or 0
+//
+//go:nosplit
+func (m *physmem) alloc() uintptr {
+	if m.n <= 0 || m.n > len(m.ranges) {
+		return 0
+	}
+	r := &m.ranges[m.n-1]
+	p := r.start
+	r.start += PGSIZE
+	if r.start == r.end {
+		m.n--
+	}
+	return p
+}
@@ -31,9 +31,10 @@ func PoolInit(p *Pool, size uintptr) {
 //go:nosplit
 func (p *Pool) grow() {
 	start := kmm.alloc()
+	kmm.stat.poolPages++
 	end := start + PGSIZE
 	for v := start; v+p.size <= end; v += p.size {
-		p.Free(v)
+		p.push(v)
 	}
 }
 
@@ -46,11 +47,18 @@ func (p *Pool) Alloc() uintptr {
 	h := (*memblk)(unsafe.Pointer(p.head))
 	p.head = h.next
 	sys.Memclr(ret, int(p.size))
+	kmm.stat.poolInuse += p.size
 	return ret
 }
 
 //go:nosplit
 func (p *Pool) Free(ptr uintptr) {
+	p.push(ptr)
+	kmm.stat.poolInuse -= p.size
+}
+
+//go:nosplit
+func (p *Pool) push(ptr uintptr) {
 	v := (*memblk)(unsafe.Pointer(ptr))
 	v.next = p.head
 	p.head = ptr
@@ -0,0 +1,57 @@
+package mm
+
+import "github.com/banditmoscow1337/spos/drivers/multiboot"
+
+// Stats is a snapshot of the memory usage, page counts are in PGSIZE
+type Stats struct {
+	// physical pages managed by the kernel, and the ones free and in use
+	TotalPages int
+	FreePages  int
+	UsedPages  int
+	// pages used by the page tables
+	PageTablePages int
+	// pages owned by Pools and the bytes allocated from them
+	PoolPages int
+	PoolInuse uintptr
+	// the virtual address in use above VMSTART and the free ranges below the break
+	VirtualUsed uintptr
+	VirtualFree uintptr
+}
+
+// Region is a physical memory range of the multiboot memory map
+type Region struct {
+	Addr uint64
+	Len  uint64
+	Type uint32
+}
+
+// Stat returns the memory usage, it reads the counters without locking
+// so the fields may be slightly inconsistent.
+func Stat() Stats {
+	s := kmm.stat
+	return Stats{
+		TotalPages:     s.total,
+		FreePages:      s.free,
+		UsedPages:      s.total - s.free,
+		PageTablePages: s.pagetables,
+		PoolPages:      s.poolPages,
+		PoolInuse:      s.poolInuse,
+		VirtualUsed:    kmm.voffset - VMSTART - kmm.vspace.free,
+		VirtualFree:    kmm.vspace.free,
+	}
+}
+
+// ReservedRegions returns the entries of the multiboot memory map not available to the kernel
+func ReservedRegions() []Region {
+	if !multiboot.Enabled() {
+		return nil
+	}
+	var regions []Region
+	for _, e := range multiboot.BootInfo.MmapEntries() {
+		if e.Type == multiboot.MemoryAvailable {
+			continue
+		}
+		regions = append(regions, Region{Addr: e.Addr, Len: e.Len, Type: e.Type})
+	}
+	return regions
+}
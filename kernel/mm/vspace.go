@@ -0,0 +1,91 @@
+package mm
+
+const (
+	// the free ranges beyond this are leaked, it's only reached
+	// with a badly fragmented address space
+	_MAX_VRANGES = 512
+)
+
+// vrange is the virtual address range [start, end)
+type vrange struct {
+	start, end uintptr
+}
+
+// vspace tracks the free virtual address below the break of kmm,
+// the ranges are sorted by address and never adjacent.
+type vspace struct {
+	ranges [_MAX_VRANGES]vrange
+	n      int
+	// bytes in all free ranges
+	free uintptr
+}
+
+// alloc returns the start of the first free range fitting n bytes, or 0
+//
+//go:nosplit
+func (s *vspace) alloc(n uintptr) uintptr {
+	for i := 0; i < s.n; i++ {
+		r := &s.ranges[i]
+		if r.end-r.start < n {
+			continue
+		}
+		va := r.start
+		r.start += n
+		if r.start == r.end {
+			s.remove(i)
+		}
+		s.free -= n
+		return va
+	}
+	return 0
+}
+
+//go:nosplit
+func (s *vspace) remove(i int) {
+	copy(s.ranges[i:s.n-1], s.ranges[i+1:s.n])
+	s.n--
+}
+
+// release returns [start, end) to the free ranges, merging it with the neighbours
+//
+//go:nosplit
+func (s *vspace) release(start, end uintptr) {
+	// the index of the first range after start
+	i := 0
+	for i < s.n && s.ranges[i].start < start {
+		i++
+	}
+	if i > 0 && s.ranges[i-1].end > start || i < s.n && s.ranges[i].start < end {
+		throw("vspace double free")
+	}
+	s.free += end - start
+	prev := i > 0 && s.ranges[i-1].end == start
+	next := i < s.n && s.ranges[i].start == end
+	switch {
+	case prev && next:
+		s.ranges[i-1].end = s.ranges[i].end
+		s.remove(i)
+	case prev:
+		s.ranges[i-1].end = end
+	case next:
+		s.ranges[i].start = start
+	default:
+		if s.n == len(s.ranges) {
+			s.free -= end - start
+			return
+		}
+		copy(s.ranges[i+1:s.n+1], s.ranges[i:s.n])
+		s.ranges[i] = vrange{start, end}
+		s.n++
+	}
+}
+
+// last returns the free range with the highest address
+//
+//go:nosplit
+func (s *vspace) last() *vrange {
+	if s.n == 0 {
+		return nil
+	}
+	return &s.ranges[s.n-1]
+}
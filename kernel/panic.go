@@ -11,6 +11,15 @@ import (
 
 var (
 	panicPcs [32]uintptr
+
+	threadStateNames = [...]string{
+		UNUSED:   "unused",
+		INITING:  "initing",
+		SLEEPING: "sleeping",
+		RUNNABLE: "runnable",
+		RUNNING:  "running",
+		EXIT:     "exit",
+	}
 )
 
 //go:nosplit
@@ -27,35 +36,108 @@ func throwtf(tf *trapFrame, msg string) {
 	uart.WriteString(msg)
 	uart.WriteByte('\n')
 
-	log.PrintStr("0x")
-	log.PrintHex(tf.IP)
-	log.PrintStr("\n")
+	dumpRegs(tf)
+	log.PrintStr("\nstack:\n")
+	printFrame(tf.IP, false)
 	for i := 0; i < n; i++ {
-		log.PrintStr("0x")
-		log.PrintHex(panicPcs[i])
-		log.PrintStr("\n")
+		printFrame(panicPcs[i], true)
+	}
+	log.PrintStr("\n")
+	dumpThreads()
+	if gdbEnabled {
+		gdbPanic(tf)
 	}
 
 	qemu.Exit(0xff)
-	for {
-	}
+	halt()
 }
 
 //go:nosplit
 func callers(tf *trapFrame, pcs []uintptr) int {
 	fp := tf.BP
 	var i int
+	if fp == 0 || fp%sys.PtrSize != 0 {
+		return 0
+	}
 	for i = 0; i < len(pcs); i++ {
 		pc := deref(fp + 8)
 		pcs[i] = pc
 		fp = deref(fp)
-		if fp == 0 {
+		if fp == 0 || fp%sys.PtrSize != 0 {
 			break
 		}
 	}
 	return i
 }
 
+//go:nosplit
+func dumpRegs(tf *trapFrame) {
+	my := Mythread()
+	printReg("tid", uintptr(my.id))
+	printReg("cpu", uintptr(my.cpuid))
+	printReg("no", tf.Trapno)
+	printReg("err", tf.Err)
+	printReg("cr2", sys.Cr2())
+	printReg("ip", tf.IP)
+	printReg("cs", tf.CS)
+	printReg("flags", tf.FLAGS)
+	printReg("sp", tf.SP)
+	printReg("ss", tf.SS)
+	printReg("ax", tf.AX)
+	printReg("bx", tf.BX)
+	printReg("cx", tf.CX)
+	printReg("dx", tf.DX)
+	printReg("si", tf.SI)
+	printReg("di", tf.DI)
+	printReg("bp", tf.BP)
+	printReg("r8", tf.R8)
+	printReg("r9", tf.R9)
+	printReg("r10", tf.R10)
+	printReg("r11", tf.R11)
+	printReg("r12", tf.R12)
+	printReg("r13", tf.R13)
+	printReg("r14", tf.R14)
+	printReg("r15", tf.R15)
+}
+
+// dumpThreads prints the state of all threads and where they trapped last time
+//
+//go:nosplit
+func dumpThreads() {
+	my := Mythread()
+	log.PrintStr("threads:\n")
+	for i := 0; i < nthreads; i++ {
+		t := threads[i].ptr()
+		if t == nil {
+			continue
+		}
+		if t == my {
+			log.PrintStr("* ")
+		} else {
+			log.PrintStr("  ")
+		}
+		log.PrintInt(t.id)
+		log.PrintStr(" ")
+		if t.state < len(threadStateNames) {
+			log.PrintStr(threadStateNames[t.state])
+		}
+		log.PrintStr(" cpu=")
+		log.PrintInt(t.cpuid)
+		if t.idle {
+			log.PrintStr(" idle")
+		}
+		if t.tf != nil {
+			log.PrintStr(" ip=0x")
+			log.PrintHex(t.tf.IP)
+			if name, _, _, _, ok := symbolize(t.tf.IP); ok {
+				log.PrintStr(" ")
+				log.PrintStr(name)
+			}
+		}
+		log.PrintStr("\n")
+	}
+}
+
 //go:nosplit
 func deref(addr uintptr) uintptr {
 	return *(*uintptr)(unsafe.Pointer(addr))
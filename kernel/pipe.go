@@ -5,6 +5,7 @@ import (
 	"unsafe"
 
 	"github.com/banditmoscow1337/spos/kernel/isyscall"
+	"github.com/banditmoscow1337/spos/kernel/sys"
 )
 
 // Timer depends on epoll and pipe.
@@ -17,13 +18,36 @@ const (
 )
 
 var (
-	// FIXME:
-	// avoid dup create pipe
+	// only the pipe of the netpoller is made by the kernel
 	epollPipeCreated bool
 	// the bytes number in pipe
 	pipeBufferBytes int
 )
 
+// runtimePipe2 is the only caller of pipe2 in the runtime, the pipe of the netpoller
+//
+//go:linkname runtimePipe2 runtime.pipe2
+func runtimePipe2(flags int32) (r, w int32, errno int32)
+
+// isEpollPipe reports whether the pipe2 called at pc is the one of the
+// runtime netpoller, the pipes of the programs are made by the vfs.
+//
+//go:nosplit
+func isEpollPipe(pc uintptr) bool {
+	if epollPipeCreated {
+		return false
+	}
+	f := findfunc(pc)
+	return f.fn != nil && f.fn == findfunc(sys.FuncPC(runtimePipe2)).fn
+}
+
+// isEpollPipeFd reports whether the kernel serves the reads and writes of fd
+//
+//go:nosplit
+func isEpollPipeFd(fd uintptr) bool {
+	return epollPipeCreated && (fd == pipeReadFd || fd == pipeWriteFd)
+}
+
 //go:nosplit
 func sysPipe2(req *isyscall.Request) {
 	if epollPipeCreated {
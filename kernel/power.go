@@ -0,0 +1,66 @@
+package kernel
+
+import (
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"github.com/banditmoscow1337/spos/drivers/acpi"
+	"github.com/banditmoscow1337/spos/drivers/qemu"
+	"github.com/banditmoscow1337/spos/gvisor/linux/errno"
+	"github.com/banditmoscow1337/spos/kernel/isyscall"
+	"github.com/banditmoscow1337/spos/kernel/sys"
+	"github.com/banditmoscow1337/spos/log"
+)
+
+var (
+	// an empty idt, any exception becomes a triple fault
+	nullIdtptr [10]byte
+)
+
+// halt stops the current cpu forever
+//
+//go:nosplit
+func halt() {
+	sys.Cli()
+	for {
+		sys.Hlt()
+	}
+}
+
+// poweroff exits qemu with code if the debug-exit device is present,
+// or enters the acpi S5 state.
+//
+//go:nosplit
+func poweroff(code int) {
+	qemu.Exit(code)
+	acpi.Poweroff()
+	log.PrintStr("[power] poweroff failed, halt\n")
+	halt()
+}
+
+// reboot resets the machine, a triple fault is the last resort
+//
+//go:nosplit
+func reboot() {
+	acpi.Reset()
+	lidt(uintptr(unsafe.Pointer(&nullIdtptr)))
+	runtime.Breakpoint()
+	halt()
+}
+
+//go:nosplit
+func sysReboot(req *isyscall.Request) {
+	if req.Arg(0) != syscall.LINUX_REBOOT_MAGIC1 || req.Arg(1) != syscall.LINUX_REBOOT_MAGIC2 {
+		req.SetRet(isyscall.Errno(errno.EINVAL))
+		return
+	}
+	switch req.Arg(2) {
+	case syscall.LINUX_REBOOT_CMD_POWER_OFF, syscall.LINUX_REBOOT_CMD_HALT:
+		poweroff(0)
+	case syscall.LINUX_REBOOT_CMD_RESTART:
+		reboot()
+	default:
+		req.SetRet(isyscall.Errno(errno.EINVAL))
+	}
+}
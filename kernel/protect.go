@@ -0,0 +1,109 @@
+package kernel
+
+import (
+	"github.com/banditmoscow1337/spos/kernel/mm"
+	"github.com/banditmoscow1337/spos/kernel/sys"
+	"github.com/banditmoscow1337/spos/log"
+)
+
+const (
+	_TRAP_DOUBLE_FAULT = 8
+
+	// the double fault handler runs on its own stack,
+	// so an overflowed kernel stack can still be reported
+	_IST_DOUBLE_FAULT = 1
+	_IST_STACK_SIZE   = 16 << 10
+)
+
+//go:nosplit
+func pageRoundUp(v uintptr) uintptr {
+	return (v + mm.PGSIZE - 1) &^ (mm.PGSIZE - 1)
+}
+
+//go:nosplit
+func pageRoundDown(v uintptr) uintptr {
+	return v &^ (mm.PGSIZE - 1)
+}
+
+// kernelModule returns the module data of the kernel image
+//
+//go:nosplit
+func kernelModule() *moduledata {
+	datap := findfunc(sys.FuncPC(kernelModule)).datap
+	if datap == nil {
+		throw("kernel module not found")
+	}
+	return datap
+}
+
+// protectKernel maps the kernel text read-only, rodata and pclntab
+// read-only and no-execute, the data and bss stay writable and no-execute
+// as mm.Init mapped them.
+//
+//go:nosplit
+func protectKernel() {
+	datap := kernelModule()
+	text := pageRoundDown(datap.text)
+	etext := pageRoundUp(datap.etext)
+	rodataEnd := pageRoundDown(datap.noptrdata)
+	mm.Protect(text, etext-text, mm.PERM_TEXT)
+	if rodataEnd > etext {
+		mm.Protect(etext, rodataEnd-etext, mm.PERM_RODATA)
+	}
+	if mm.NXEnabled() {
+		log.PrintStr("[mm] nx enabled\n")
+	}
+}
+
+// istInit allocates the interrupt stacks of cpu c
+//
+//go:nosplit
+func istInit(c *cpu) {
+	stack := mm.Mmap(0, _IST_STACK_SIZE) + _IST_STACK_SIZE
+	setTssIST(c, _IST_DOUBLE_FAULT, stack)
+}
+
+// stackGuard returns the guard page under the stack whose top is top
+//
+//go:nosplit
+func stackGuard(top uintptr) uintptr {
+	return top - (_THREAD_STACK_SIZE - _THREAD_STACK_GUARD_OFFSET) - mm.PGSIZE
+}
+
+// checkStackOverflow panics if addr is in the guard page of a thread stack
+//
+//go:nosplit
+func checkStackOverflow(tf *trapFrame, addr uintptr) {
+	for i := 0; i < nthreads; i++ {
+		t := threads[i].ptr()
+		if t == nil || t.state == UNUSED {
+			continue
+		}
+		var which string
+		switch {
+		case t.kstack != 0 && addr-stackGuard(t.kstack) < mm.PGSIZE:
+			which = "kernel stack"
+		case t.ownStack && t.stack != 0 && addr-stackGuard(t.stack) < mm.PGSIZE:
+			which = "stack"
+		default:
+			continue
+		}
+		log.PrintStr("stack overflow: tid=")
+		log.PrintInt(t.id)
+		log.PrintStr(" ")
+		log.PrintStr(which)
+		log.PrintStr(" addr=0x")
+		log.PrintHex(addr)
+		log.PrintStr("\n")
+		throwtf(tf, "stack overflow")
+	}
+}
+
+//go:nosplit
+func doubleFaultHandler() {
+	tf := Mythread().tf
+	checkStackOverflow(tf, sys.Cr2())
+	// the fault of pushing the trap frame of another fault
+	checkStackOverflow(tf, tf.SP-sys.PtrSize)
+	trapPanic()
+}
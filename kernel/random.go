@@ -0,0 +1,165 @@
+package kernel
+
+import (
+	"unsafe"
+
+	"github.com/banditmoscow1337/spos/kernel/sys"
+)
+
+// The entropy pool collects rdseed/rdrand outputs, tsc jitter and irq timings,
+// the pool is mixed by the chacha permutation and reseeds a chacha20 based crng.
+// All functions run with the kernel lock held.
+
+const (
+	_CPUID_ECX_RDRAND = 1 << 30
+	_CPUID_EBX_RDSEED = 1 << 18
+
+	// samples of tsc jitter collected at boot
+	_JITTER_SAMPLES = 1024
+	// the crng is reseeded after this number of irq samples
+	_RESEED_SAMPLES = 256
+	// the crng is reseeded at least this often when it's used
+	_RESEED_INTERVAL = 60 * second
+
+	_CHACHA_BLOCK_SIZE = 64
+)
+
+var (
+	hasRDRAND bool
+	hasRDSEED bool
+
+	entropyPool struct {
+		state [16]uint32
+		idx   int
+		// samples mixed since the last reseed
+		samples int
+	}
+
+	crng struct {
+		key     [8]uint32
+		counter uint64
+		// time of the last reseed
+		seedTime int64
+	}
+)
+
+// entropyMix xors v into the pool, the pool is permuted when a full block is filled
+//
+//go:nosplit
+func entropyMix(v uint64) {
+	p := &entropyPool
+	p.state[p.idx] ^= uint32(v)
+	p.state[p.idx+1] ^= uint32(v >> 32)
+	p.idx += 2
+	if p.idx == len(p.state) {
+		p.idx = 0
+		chachaBlock(&p.state, &p.state)
+	}
+	p.samples++
+}
+
+// entropyAddIRQ mixes the timing of irq no into the pool
+//
+//go:nosplit
+func entropyAddIRQ(no uintptr) {
+	entropyMix(sys.Rdtsc() ^ uint64(no)<<56)
+}
+
+//go:nosplit
+func entropyHWRand() (uint64, bool) {
+	// both may transiently fail, retry a few times as intel suggests
+	for i := 0; i < 10; i++ {
+		if hasRDSEED {
+			if v, ok := sys.Rdseed(); ok {
+				return v, true
+			}
+		}
+		if hasRDRAND {
+			if v, ok := sys.Rdrand(); ok {
+				return v, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// entropyJitter samples the latency of port io, it varies with the cache,
+// bus and smi activity.
+//
+//go:nosplit
+func entropyJitter(n int) {
+	last := sys.Rdtsc()
+	for i := 0; i < n; i++ {
+		pitCounter()
+		now := sys.Rdtsc()
+		entropyMix((now - last) ^ now<<32)
+		last = now
+	}
+}
+
+// crngReseed derives a new key from the pool and the old key
+//
+//go:nosplit
+func crngReseed() {
+	p := &entropyPool
+	if v, ok := entropyHWRand(); ok {
+		entropyMix(v)
+	}
+	entropyMix(uint64(nanosecond()))
+	chachaBlock(&p.state, &p.state)
+	for i := range crng.key {
+		crng.key[i] ^= p.state[i]
+	}
+	// the pool state must not reveal the key
+	chachaBlock(&p.state, &p.state)
+	p.samples = 0
+	crng.seedTime = nanosecond()
+}
+
+//go:nosplit
+func crngBlock(out *[16]uint32) {
+	in := [16]uint32{
+		_CHACHA_C0, _CHACHA_C1, _CHACHA_C2, _CHACHA_C3,
+	}
+	copy(in[4:12], crng.key[:])
+	in[12] = uint32(crng.counter)
+	in[13] = uint32(crng.counter >> 32)
+	crng.counter++
+	chachaBlock(out, &in)
+}
+
+// randomRead fills buf with the output of the crng
+//
+//go:nosplit
+func randomRead(buf []byte) {
+	if entropyPool.samples >= _RESEED_SAMPLES || nanosecond()-crng.seedTime > _RESEED_INTERVAL {
+		crngReseed()
+	}
+	var blk [16]uint32
+	out := (*[_CHACHA_BLOCK_SIZE]byte)(unsafe.Pointer(&blk))
+	for len(buf) > 0 {
+		crngBlock(&blk)
+		n := copy(buf, out[:])
+		buf = buf[n:]
+	}
+	// fast key erasure, the output already returned can't be recovered from the new key
+	crngBlock(&blk)
+	copy(crng.key[:], blk[:8])
+	blk = [16]uint32{}
+}
+
+//go:nosplit
+func randomInit() {
+	_, _, ecx, _ := cpuid(_CPUID_FN_STD, 0)
+	hasRDRAND = ecx&_CPUID_ECX_RDRAND != 0
+	_, ebx, _, _ := cpuid(0x0007, 0)
+	hasRDSEED = ebx&_CPUID_EBX_RDSEED != 0
+
+	for i := 0; i < len(entropyPool.state); i++ {
+		if v, ok := entropyHWRand(); ok {
+			entropyMix(v)
+		}
+	}
+	entropyJitter(_JITTER_SAMPLES)
+	crngReseed()
+}
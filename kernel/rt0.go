@@ -1,10 +1,12 @@
 package kernel
 
 import (
+	"github.com/banditmoscow1337/spos/drivers/acpi"
+	"github.com/banditmoscow1337/spos/drivers/irq"
 	"github.com/banditmoscow1337/spos/drivers/multiboot"
-	"github.com/banditmoscow1337/spos/drivers/pic"
 	"github.com/banditmoscow1337/spos/drivers/uart"
 	"github.com/banditmoscow1337/spos/kernel/mm"
+	"github.com/banditmoscow1337/spos/log"
 )
 
 //go:nosplit
@@ -22,15 +24,27 @@ func rdmsr(reg uint32) (value uintptr)
 //go:nosplit
 func preinit(magic, mbiptr uintptr) {
 	simdInit()
-	gdtInit()
+	gdtInit(&cpus[0])
 	idtInit()
 	multiboot.Init(magic, mbiptr)
-	mm.Init()
+	datap := kernelModule()
+	mm.Init(datap.text, datap.etext)
+	protectKernel()
+	istInit(&cpus[0])
 	uart.PreInit()
+	if n := mm.DroppedMemory(); n != 0 {
+		log.PrintStr("[mm] memory above 1 TiB is not used, dropped 0x")
+		log.PrintHex(uintptr(n))
+		log.PrintStr(" bytes\n")
+	}
 	syscallInit()
 	trapInit()
 	threadInit()
-	pic.Init()
+	acpi.Init()
+	irq.Init()
 	timerInit()
-	schedule()
+	randomInit()
+	smpInit()
+	kernelLock(0)
+	schedule(&cpus[0])
 }
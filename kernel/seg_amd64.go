@@ -20,13 +20,8 @@ const (
 )
 
 var (
-	gdt    [7]gdtSegDesc
-	gdtptr [10]byte
-
 	idt    [256]idtSetDesc
 	idtptr [10]byte
-
-	tss [26]uint32
 )
 
 type gdtSegDesc [8]byte
@@ -84,22 +79,25 @@ func setTssDesc(lo, hi *gdtSegDesc, addr, limit uintptr) {
 	*(*uint32)(unsafe.Pointer(hi)) = uint32(addr >> 32)
 }
 
+// gdtInit loads the gdt and tss of cpu c, every cpu has its own tss
+//
 //go:nosplit
-func gdtInit() {
+func gdtInit(c *cpu) {
+	gdt := &c.gdt
 	// leave gdt[0] untouched
 	setGdtCodeDesc(&gdt[_KCODE_IDX], segDplKernel)
 	setGdtDataDesc(&gdt[_KDATA_IDX], segDplKernel)
 	setGdtCodeDesc(&gdt[_UCODE_IDX], segDplUser)
 	setGdtDataDesc(&gdt[_UDATA_IDX], segDplUser)
-	tssAddr := uintptr(unsafe.Pointer(&tss[0]))
-	tssLimit := uintptr(unsafe.Sizeof(tss)) - 1
+	tssAddr := uintptr(unsafe.Pointer(&c.tss[0]))
+	tssLimit := uintptr(unsafe.Sizeof(c.tss)) - 1
 	setTssDesc(&gdt[_TSS_IDX], &gdt[_TSS_IDX+1], tssAddr, tssLimit)
 
-	limit := (*uint16)(unsafe.Pointer(&gdtptr[0]))
-	base := (*uint64)(unsafe.Pointer(&gdtptr[2]))
-	*limit = uint16(unsafe.Sizeof(gdt) - 1)
+	limit := (*uint16)(unsafe.Pointer(&c.gdtptr[0]))
+	base := (*uint64)(unsafe.Pointer(&c.gdtptr[2]))
+	*limit = uint16(unsafe.Sizeof(*gdt) - 1)
 	*base = uint64(uintptr(unsafe.Pointer(&gdt[0])))
-	lgdt(uintptr(unsafe.Pointer(&gdtptr[0])))
+	lgdt(uintptr(unsafe.Pointer(&c.gdtptr[0])))
 	ltr(_TSS_IDX << 3)
 	reloadCS()
 }
@@ -113,22 +111,45 @@ func setIdtDesc(desc *idtSetDesc, addr uintptr, dpl byte) {
 	desc.Addr3 = uint32(addr>>32) & 0xffffffff
 }
 
+// setIdtIST makes the cpu switch to the interrupt stack ist on entry
+//
+//go:nosplit
+func setIdtIST(desc *idtSetDesc, ist int) {
+	desc.Attr = desc.Attr&^0x7 | uint16(ist)
+}
+
 //go:nosplit
 func idtInit() {
 	for i := 0; i < 256; i++ {
 		setIdtDesc(&idt[i], sys.FuncPC(vectors[i]), segDplKernel)
 	}
 	setIdtDesc(&idt[0x80], sys.FuncPC(vectors[0x80]), segDplUser)
+	setIdtIST(&idt[_TRAP_DOUBLE_FAULT], _IST_DOUBLE_FAULT)
 
 	limit := (*uint16)(unsafe.Pointer(&idtptr[0]))
 	base := (*uint64)(unsafe.Pointer(&idtptr[2]))
 	*limit = uint16(unsafe.Sizeof(idt) - 1)
 	*base = uint64(uintptr(unsafe.Pointer(&idt[0])))
+	idtLoad()
+}
+
+// idtLoad loads the shared idt on the current cpu
+//
+//go:nosplit
+func idtLoad() {
 	lidt(uintptr(unsafe.Pointer(&idtptr)))
 }
 
 //go:nosplit
-func setTssSP0(addr uintptr) {
-	tss[1] = uint32(addr)
-	tss[2] = uint32(addr >> 32)
+func setTssSP0(c *cpu, addr uintptr) {
+	c.tss[1] = uint32(addr)
+	c.tss[2] = uint32(addr >> 32)
+}
+
+// setTssIST sets the interrupt stack n (1-7) of cpu c
+//
+//go:nosplit
+func setTssIST(c *cpu, n int, addr uintptr) {
+	c.tss[7+2*n] = uint32(addr)
+	c.tss[8+2*n] = uint32(addr >> 32)
 }
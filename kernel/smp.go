@@ -0,0 +1,291 @@
+package kernel
+
+import (
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/banditmoscow1337/spos/drivers/acpi"
+	"github.com/banditmoscow1337/spos/drivers/apic"
+	"github.com/banditmoscow1337/spos/kernel/mm"
+	"github.com/banditmoscow1337/spos/kernel/sys"
+	"github.com/banditmoscow1337/spos/log"
+)
+
+const (
+	_MAX_CPUS = acpi.MaxCPUs
+
+	// the physical address of the ap trampoline, must be page aligned
+	// and below 1M since application processors start in real mode
+	_AP_BOOT_ADDR = 0x7000
+
+	_AP_STACK_SIZE = 16 << 10
+
+	// offsets of the trampoline parameters, sync with apTrampoline
+	_AP_BOOT_CR3   = 0x80
+	_AP_BOOT_STACK = 0x88
+	_AP_BOOT_ENTRY = 0x90
+	_AP_BOOT_ARG   = 0x98
+	// the second byte of the immediate or'ed to EFER
+	_AP_BOOT_EFER_HI = 0x27
+	_EFER_NXE_HI     = 0x08
+
+	_IRQ_LAPIC_TIMER = apic.TimerVector
+	_IRQ_RESCHEDULE  = apic.RescheduleVector
+)
+
+var (
+	cpus [_MAX_CPUS]cpu
+	ncpu = 1
+
+	// kernelMutex is the big kernel lock, held by a cpu from trap entry to trapret
+	kernelMutex spinlock
+
+	// ticks of the local apic timer in one scheduler tick
+	lapicTimerTicks uint32
+
+	// fs base used by the autogenerated wrappers before the ap enters a thread
+	apFakeTLS [2]uintptr
+
+	// apTrampoline starts an application processor from real mode
+	// directly into long mode, using the page table of the bsp.
+	// Hand assembled, the origin is _AP_BOOT_ADDR.
+	apTrampoline = [...]byte{
+		// .code16
+		0xfa,       // 0x00: cli
+		0x31, 0xc0, // 0x01: xor ax, ax
+		0x8e, 0xd8, // 0x03: mov ds, ax
+		0x66, 0x0f, 0x01, 0x16, 0x60, 0x70, // 0x05: lgdt dword [0x7060]
+		0x0f, 0x20, 0xe0, // 0x0b: mov eax, cr4
+		0x66, 0x83, 0xc8, 0x20, // 0x0e: or eax, 0x20 (PAE)
+		0x0f, 0x22, 0xe0, // 0x12: mov cr4, eax
+		0x66, 0xa1, 0x80, 0x70, // 0x15: mov eax, [0x7080]
+		0x0f, 0x22, 0xd8, // 0x19: mov cr3, eax
+		0x66, 0xb9, 0x80, 0x00, 0x00, 0xc0, // 0x1c: mov ecx, 0xc0000080 (EFER)
+		0x0f, 0x32, // 0x22: rdmsr
+		0x66, 0x0d, 0x00, 0x01, 0x00, 0x00, // 0x24: or eax, 0x100 (LME), NXE is patched by startAP
+		0x0f, 0x30, // 0x2a: wrmsr
+		0x0f, 0x20, 0xc0, // 0x2c: mov eax, cr0
+		0x66, 0x0d, 0x01, 0x00, 0x01, 0x80, // 0x2f: or eax, 0x80010001 (PG|WP|PE)
+		0x0f, 0x22, 0xc0, // 0x35: mov cr0, eax
+		0x66, 0xea, 0x40, 0x70, 0x00, 0x00, 0x08, 0x00, // 0x38: jmp dword 0x8:0x7040
+
+		// .code64
+		0x48, 0x8b, 0x24, 0x25, 0x88, 0x70, 0x00, 0x00, // 0x40: mov rsp, [0x7088]
+		0x48, 0x8b, 0x3c, 0x25, 0x98, 0x70, 0x00, 0x00, // 0x48: mov rdi, [0x7098]
+		0x48, 0x8b, 0x04, 0x25, 0x90, 0x70, 0x00, 0x00, // 0x50: mov rax, [0x7090]
+		0xff, 0xe0, // 0x58: jmp rax
+		0xf4,       // 0x5a: hlt
+		0xeb, 0xfd, // 0x5b: jmp 0x5a
+		0x00, 0x00, 0x00,
+
+		// 0x60: gdt descriptor, limit and base
+		0x17, 0x00, 0x68, 0x70, 0x00, 0x00,
+		0x00, 0x00,
+
+		// 0x68: null, 64 bit code and data descriptors
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x9a, 0x20, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x92, 0x00, 0x00,
+
+		// 0x80: cr3, stack, entry and arg are filled by startAP
+	}
+)
+
+//go:notinheap
+type cpu struct {
+	id     int
+	apicid uint8
+
+	scheduler *context
+	idle      threadptr
+	// index of the last picked thread
+	pidx int
+
+	started uint32
+
+	gdt    [7]gdtSegDesc
+	gdtptr [10]byte
+	tss    [26]uint32
+}
+
+type spinlock struct {
+	locked uint32
+	// owner is cpu id + 1, used for recursive lock on nested traps
+	owner int
+	depth int
+}
+
+//go:nosplit
+func (l *spinlock) lock(cpuid int) {
+	if l.owner == cpuid+1 {
+		l.depth++
+		return
+	}
+	for !atomic.CompareAndSwapUint32(&l.locked, 0, 1) {
+	}
+	l.owner = cpuid + 1
+	l.depth = 1
+}
+
+//go:nosplit
+func (l *spinlock) unlock() {
+	l.depth--
+	if l.depth > 0 {
+		return
+	}
+	l.owner = 0
+	atomic.StoreUint32(&l.locked, 0)
+}
+
+//go:nosplit
+func kernelLock(cpuid int) {
+	kernelMutex.lock(cpuid)
+}
+
+//go:nosplit
+func kernelUnlock() {
+	kernelMutex.unlock()
+}
+
+// NumCPU returns the number of started cpus
+func NumCPU() int {
+	return ncpu
+}
+
+//go:nosplit
+func apentry()
+
+// lapicTimerIntr expires the timer queue and preempts the current thread
+//
+//go:nosplit
+func lapicTimerIntr() {
+	apic.EOI()
+	timerExpire()
+	Yield()
+}
+
+// rescheduleIntr is sent by kickIdle, the idle thread leaves hlt and picks a thread
+//
+//go:nosplit
+func rescheduleIntr() {
+	apic.EOI()
+	Yield()
+}
+
+// kickIdle wakes up to n idle cpus after threads became runnable.
+// It's only needed in one-shot mode, where nothing else interrupts an idle cpu
+// until the timer queue expires. The kernel lock must be held.
+//
+//go:nosplit
+func kickIdle(n int) {
+	if !oneshot || n <= 0 {
+		return
+	}
+	me := Mythread().cpuid
+	for i := 0; i < ncpu && n > 0; i++ {
+		c := &cpus[i]
+		if c.id == me || c.idle.ptr().state != RUNNING {
+			continue
+		}
+		apic.SendFixed(c.apicid, _IRQ_RESCHEDULE)
+		n--
+	}
+}
+
+// lapicTimerCalibrate measures the local apic timer ticks of one scheduler tick
+//
+//go:nosplit
+func lapicTimerCalibrate() {
+	const max = 0xffffffff
+	apic.StartTimer(_IRQ_LAPIC_TIMER, max, false, true)
+	pitDelay(second / _HZ)
+	lapicTimerTicks = max - apic.TimerCount()
+	apic.StopTimer()
+}
+
+// apmain is the first go function run by an application processor
+//
+//go:nosplit
+func apmain(idx uintptr) {
+	c := &cpus[idx]
+	simdInit()
+	gdtInit(c)
+	idtLoad()
+	syscallMSRInit()
+	apic.InitAP()
+	// with one-shot mode the timer is armed by the scheduler
+	if !oneshot {
+		apic.StartTimer(_IRQ_LAPIC_TIMER, lapicTimerTicks, true, false)
+	}
+	atomic.StoreUint32(&c.started, 1)
+
+	kernelLock(c.id)
+	schedule(c)
+}
+
+//go:nosplit
+func startAP(c *cpu) bool {
+	idleThreadInit(c)
+
+	boot := sys.UnsafeBuffer(_AP_BOOT_ADDR, mm.PGSIZE)
+	copy(boot, apTrampoline[:])
+	// the page table has the no-execute bit set, which is reserved without EFER.NXE
+	if mm.NXEnabled() {
+		boot[_AP_BOOT_EFER_HI] |= _EFER_NXE_HI
+	}
+	istInit(c)
+	stack := mm.Mmap(0, _AP_STACK_SIZE) + _AP_STACK_SIZE
+	*(*uintptr)(unsafe.Pointer(&boot[_AP_BOOT_CR3])) = mm.TopPage()
+	*(*uintptr)(unsafe.Pointer(&boot[_AP_BOOT_STACK])) = stack
+	*(*uintptr)(unsafe.Pointer(&boot[_AP_BOOT_ENTRY])) = sys.FuncPC(apentry)
+	*(*uintptr)(unsafe.Pointer(&boot[_AP_BOOT_ARG])) = uintptr(c.id)
+
+	// INIT-SIPI-SIPI sequence
+	apic.SendInit(c.apicid)
+	pitDelay(10 * ms)
+	for i := 0; i < 2 && atomic.LoadUint32(&c.started) == 0; i++ {
+		apic.SendStartup(c.apicid, _AP_BOOT_ADDR>>12)
+		pitDelay(200 * 1000 * ns)
+	}
+	// wait the ap leave the trampoline, it's shared by all aps
+	for i := 0; i < 100 && atomic.LoadUint32(&c.started) == 0; i++ {
+		pitDelay(ms)
+	}
+	return atomic.LoadUint32(&c.started) != 0
+}
+
+// smpInit finds the application processors in the MADT and starts them.
+// Must be called after irq.Init and timerInit, the bsp holds no kernel lock here.
+//
+//go:nosplit
+func smpInit() {
+	madt := &acpi.MADT
+	if madt.NCPU <= 1 {
+		return
+	}
+	// already enabled if the irqs are routed by the IOAPIC
+	if !apic.Enabled() {
+		apic.Init(madt.LAPICAddr)
+	}
+	if lapicTimerTicks == 0 {
+		lapicTimerCalibrate()
+	}
+
+	bspid := apic.ID()
+	cpus[0].apicid = bspid
+	for i := 0; i < madt.NCPU; i++ {
+		apicid := madt.CPUs[i].APICID
+		if apicid == bspid {
+			continue
+		}
+		c := &cpus[ncpu]
+		c.id = ncpu
+		c.apicid = apicid
+		if !startAP(c) {
+			log.PrintStr("[smp] failed to start cpu\n")
+			c.idle.ptr().state = EXIT
+			continue
+		}
+		ncpu++
+	}
+}
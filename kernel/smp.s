@@ -0,0 +1,23 @@
+#include "textflag.h"
+
+// apentry is jumped to by the ap trampoline in long mode,
+// SP is the ap boot stack and DI stores the cpu index.
+TEXT ·apentry(SB), NOSPLIT, $0-0
+	MOVQ DI, BX
+
+	// same as rt0, sse must be enabled before entering go code
+	CALL ·sseInit(SB)
+
+	// and %fs must point to a valid address
+	MOVL $0xc0000100, CX // _MSR_FS_BASE
+	LEAQ ·apFakeTLS+8(SB), AX
+	MOVQ AX, DX
+	SHRQ $32, DX
+	WRMSR
+
+	SUBQ $0x10, SP
+	MOVQ BX, 0(SP)
+	CALL ·apmain(SB)
+	INT  $3
+
+	// never return
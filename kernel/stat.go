@@ -0,0 +1,171 @@
+package kernel
+
+import (
+	"math/bits"
+	"sync/atomic"
+
+	"github.com/banditmoscow1337/spos/drivers/irq"
+)
+
+const (
+	// bucket i holds the latencies below 1<<(i+_HIST_SHIFT) ns,
+	// the last one holds all the slower ones
+	HistBuckets = 24
+	_HIST_SHIFT = 10
+
+	_NR_VECTORS = 256
+)
+
+// CallStat is the count and the latency histogram of a syscall or an irq
+type CallStat struct {
+	Count uint64
+	// the total and the longest latency in nanoseconds
+	Total uint64
+	Max   uint64
+	Hist  [HistBuckets]uint64
+}
+
+// HistBound returns the upper bound in nanoseconds of the histogram bucket i
+func HistBound(i int) uint64 {
+	return 1 << (i + _HIST_SHIFT)
+}
+
+// Avg returns the mean latency in nanoseconds
+func (s *CallStat) Avg() uint64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Total / s.Count
+}
+
+// Percentile returns the upper bound of the bucket the p-th percentile falls in,
+// p is between 0 and 1.
+func (s *CallStat) Percentile(p float64) uint64 {
+	want := uint64(p * float64(s.Count))
+	var n uint64
+	for i, c := range s.Hist {
+		n += c
+		if n > want || n == s.Count && c != 0 {
+			if i == HistBuckets-1 {
+				return s.Max
+			}
+			return HistBound(i)
+		}
+	}
+	return 0
+}
+
+// add records a call of d nanoseconds, the syscalls are counted outside
+// of the kernel lock from the syscall thread, so all the fields are atomic.
+//
+//go:nosplit
+func (s *CallStat) add(d int64) {
+	if d < 0 {
+		d = 0
+	}
+	ns := uint64(d)
+	b := bits.Len64(ns >> _HIST_SHIFT)
+	if b >= HistBuckets {
+		b = HistBuckets - 1
+	}
+	atomic.AddUint64(&s.Count, 1)
+	atomic.AddUint64(&s.Total, ns)
+	atomic.AddUint64(&s.Hist[b], 1)
+	for {
+		max := atomic.LoadUint64(&s.Max)
+		if ns <= max || atomic.CompareAndSwapUint64(&s.Max, max, ns) {
+			break
+		}
+	}
+}
+
+// load returns an atomic copy of every field, not of the whole
+//
+//go:nosplit
+func (s *CallStat) load() CallStat {
+	var r CallStat
+	r.Count = atomic.LoadUint64(&s.Count)
+	r.Total = atomic.LoadUint64(&s.Total)
+	r.Max = atomic.LoadUint64(&s.Max)
+	for i := range s.Hist {
+		r.Hist[i] = atomic.LoadUint64(&s.Hist[i])
+	}
+	return r
+}
+
+var (
+	syscallStats [_TRACE_MAX_NO]CallStat
+	irqStats     [irq.NR_IRQS]CallStat
+	// the traps taken by every cpu, written by the cpu with the kernel lock held
+	trapCounts [_MAX_CPUS][_NR_VECTORS]uint64
+)
+
+//go:nosplit
+func syscallStat(no uintptr, d int64) {
+	if no < _TRACE_MAX_NO {
+		syscallStats[no].add(d)
+	}
+}
+
+//go:nosplit
+func trapCount(cpu int, no uintptr) {
+	if uint(cpu) < _MAX_CPUS && no < _NR_VECTORS {
+		atomic.AddUint64(&trapCounts[cpu][no], 1)
+	}
+}
+
+// SyscallStat is the statistics of the syscall No
+type SyscallStat struct {
+	No uintptr
+	CallStat
+}
+
+// SyscallStats returns the statistics of the syscalls called at least once
+func SyscallStats() []SyscallStat {
+	var ret []SyscallStat
+	for no := range syscallStats {
+		s := syscallStats[no].load()
+		if s.Count == 0 {
+			continue
+		}
+		ret = append(ret, SyscallStat{No: uintptr(no), CallStat: s})
+	}
+	return ret
+}
+
+// IRQStat is the statistics of the irq line Line, the latency
+// is the time spent by the handler in the trap thread.
+type IRQStat struct {
+	Line   int
+	Vector int
+	CallStat
+}
+
+// IRQStats returns the statistics of the irq lines handled at least once
+func IRQStats() []IRQStat {
+	var ret []IRQStat
+	for i := range irqStats {
+		s := irqStats[i].load()
+		if s.Count == 0 {
+			continue
+		}
+		ret = append(ret, IRQStat{Line: i, Vector: irq.IRQ_BASE + i, CallStat: s})
+	}
+	return ret
+}
+
+// TrapCounts returns the count of the trap vector taken by every cpu
+func TrapCounts(vector int) []uint64 {
+	n := NumCPU()
+	if n > _MAX_CPUS {
+		n = _MAX_CPUS
+	}
+	ret := make([]uint64, n)
+	if uint(vector) >= _NR_VECTORS {
+		return ret
+	}
+	for i := range ret {
+		ret[i] = atomic.LoadUint64(&trapCounts[i][vector])
+	}
+	return ret
+}
@@ -0,0 +1,185 @@
+package kernel
+
+import (
+	"unsafe"
+
+	"github.com/banditmoscow1337/spos/log"
+)
+
+// A nosplit and allocation free reader of the runtime pclntab,
+// used by the panic path where the go runtime can't be trusted.
+// Layouts below must be synced with runtime/symtab.go and runtime/runtime2.go.
+
+const (
+	_PCLNTAB_MAGIC = 0xfffffff1
+)
+
+// pcHeader is the head of the pclntab header
+//
+//go:notinheap
+type pcHeader struct {
+	magic uint32
+}
+
+// moduledata is the head of runtime.moduledata
+//
+//go:notinheap
+type moduledata struct {
+	pcHeader     *pcHeader
+	funcnametab  []byte
+	cutab        []uint32
+	filetab      []byte
+	pctab        []byte
+	pclntable    []byte
+	ftab         []struct{ entryoff, funcoff uint32 }
+	findfunctab  uintptr
+	minpc, maxpc uintptr
+
+	text, etext           uintptr
+	noptrdata, enoptrdata uintptr
+	data, edata           uintptr
+	bss, ebss             uintptr
+	noptrbss, enoptrbss   uintptr
+	covctrs, ecovctrs     uintptr
+	end                   uintptr
+}
+
+// _func is the head of runtime._func
+//
+//go:notinheap
+type _func struct {
+	entryOff    uint32
+	nameOff     int32
+	args        int32
+	deferreturn uint32
+	pcsp        uint32
+	pcfile      uint32
+	pcln        uint32
+	npcdata     uint32
+	cuOffset    uint32
+}
+
+// funcInfo is runtime.funcInfo
+type funcInfo struct {
+	fn    *_func
+	datap *moduledata
+}
+
+// findfunc is nosplit and kept for external use, see go.dev/issue/67401
+//
+//go:linkname findfunc runtime.findfunc
+func findfunc(pc uintptr) funcInfo
+
+// cstringAt returns the NUL terminated string at p without allocating
+//
+//go:nosplit
+func cstringAt(p uintptr) string {
+	n := 0
+	for *(*byte)(unsafe.Pointer(p + uintptr(n))) != 0 {
+		n++
+	}
+	return unsafe.String((*byte)(unsafe.Pointer(p)), n)
+}
+
+//go:nosplit
+func readvarint(p uintptr) (uintptr, uint32) {
+	var v, shift uint32
+	for {
+		b := *(*byte)(unsafe.Pointer(p))
+		p++
+		v |= uint32(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		shift += 7
+	}
+	return p, v
+}
+
+// pcvalue decodes the pc-value table at off for targetpc, like runtime.pcvalue
+//
+//go:nosplit
+func pcvalue(datap *moduledata, entry uintptr, off uint32, targetpc uintptr) int32 {
+	if off == 0 || int(off) >= len(datap.pctab) {
+		return -1
+	}
+	p := uintptr(unsafe.Pointer(&datap.pctab[off]))
+	pc := entry
+	val := int32(-1)
+	first := true
+	for {
+		var uvdelta uint32
+		if *(*byte)(unsafe.Pointer(p)) == 0 && !first {
+			return -1
+		}
+		first = false
+		p, uvdelta = readvarint(p)
+		val += int32(-(uvdelta & 1) ^ (uvdelta >> 1))
+		var pcdelta uint32
+		p, pcdelta = readvarint(p)
+		pc += uintptr(pcdelta)
+		if targetpc < pc {
+			return val
+		}
+	}
+}
+
+// symbolize returns the function name, file and line of pc
+//
+//go:nosplit
+func symbolize(pc uintptr) (name, file string, line int32, entry uintptr, ok bool) {
+	f := findfunc(pc)
+	datap := f.datap
+	if f.fn == nil || datap == nil || datap.pcHeader == nil {
+		return
+	}
+	if datap.pcHeader.magic != _PCLNTAB_MAGIC {
+		return
+	}
+	entry = datap.text + uintptr(f.fn.entryOff)
+	if f.fn.nameOff < 0 || int(f.fn.nameOff) >= len(datap.funcnametab) {
+		return
+	}
+	name = cstringAt(uintptr(unsafe.Pointer(&datap.funcnametab[f.fn.nameOff])))
+
+	fileno := pcvalue(datap, entry, f.fn.pcfile, pc)
+	line = pcvalue(datap, entry, f.fn.pcln, pc)
+	cu := int(f.fn.cuOffset) + int(fileno)
+	if fileno < 0 || line < 0 || cu >= len(datap.cutab) {
+		return name, "?", 0, entry, true
+	}
+	fileoff := datap.cutab[cu]
+	if int(fileoff) >= len(datap.filetab) {
+		return name, "?", 0, entry, true
+	}
+	file = cstringAt(uintptr(unsafe.Pointer(&datap.filetab[fileoff])))
+	return name, file, line, entry, true
+}
+
+// printFrame prints pc like the go traceback, call is true
+// if pc is a return address, which points to the next instruction of the call.
+//
+//go:nosplit
+func printFrame(pc uintptr, call bool) {
+	lookup := pc
+	if call {
+		lookup--
+	}
+	name, file, line, entry, ok := symbolize(lookup)
+	if !ok {
+		log.PrintStr("?\n\t0x")
+		log.PrintHex(pc)
+		log.PrintStr("\n")
+		return
+	}
+	log.PrintStr(name)
+	log.PrintStr("\n\t")
+	log.PrintStr(file)
+	log.PrintStr(":")
+	log.PrintInt(int(line))
+	log.PrintStr(" +0x")
+	log.PrintHex(pc - entry)
+	log.PrintStr(" pc=0x")
+	log.PrintHex(pc)
+	log.PrintStr("\n")
+}
@@ -12,6 +12,12 @@ func Outb(port uint16, data byte)
 //go:nosplit
 func Inb(port uint16) byte
 
+//go:nosplit
+func Outw(port uint16, data uint16)
+
+//go:nosplit
+func Inw(port uint16) uint16
+
 //go:nosplit
 func Outl(port uint16, data uint32)
 
@@ -67,3 +73,16 @@ func SetAX(val uintptr)
 
 //go:nosplit
 func CS() uintptr
+
+//go:nosplit
+func Rdtsc() uint64
+
+// Rdrand returns a random number from the hardware generator, ok is false if it's not ready
+//
+//go:nosplit
+func Rdrand() (v uint64, ok bool)
+
+// Rdseed is like Rdrand, but the value comes from the entropy source directly
+//
+//go:nosplit
+func Rdseed() (v uint64, ok bool)
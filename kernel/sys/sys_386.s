@@ -15,6 +15,20 @@ TEXT ·Inb(SB), NOSPLIT, $0-5
 	MOVB AX, ret+4(FP)
 	RET
 
+// Outw(port uint16, data uint16)
+TEXT ·Outw(SB), NOSPLIT, $0-4
+	MOVW port+0(FP), DX
+	MOVW data+2(FP), AX
+	OUTW
+	RET
+
+// uint16 Inw(port uint16)
+TEXT ·Inw(SB), NOSPLIT, $0-6
+	MOVW port+0(FP), DX
+	INW
+	MOVW AX, ret+4(FP)
+	RET
+
 // Outl(port uint16, data uint32)
 TEXT ·Outl(SB), NOSPLIT, $0-8
 	MOVW port+0(FP), DX
@@ -15,6 +15,20 @@ TEXT ·Inb(SB), NOSPLIT, $0-9
 	MOVB AX, ret+8(FP)
 	RET
 
+// Outw(port uint16, data uint16)
+TEXT ·Outw(SB), NOSPLIT, $0-4
+	MOVW port+0(FP), DX
+	MOVW data+2(FP), AX
+	OUTW
+	RET
+
+// uint16 Inw(port uint16)
+TEXT ·Inw(SB), NOSPLIT, $0-10
+	MOVW port+0(FP), DX
+	INW
+	MOVW AX, ret+8(FP)
+	RET
+
 // Outl(port uint16, data uint32)
 TEXT ·Outl(SB), NOSPLIT, $0-8
 	MOVW port+0(FP), DX
@@ -59,3 +73,25 @@ TEXT ·Fxsave(SB), NOSPLIT, $0-8
 	MOVQ   addr+0(FP), AX
 	FXSAVE (AX)
 	RET
+
+// uint64 Rdtsc()
+TEXT ·Rdtsc(SB), NOSPLIT, $0-8
+	RDTSC
+	SHLQ $32, DX
+	ORQ  DX, AX
+	MOVQ AX, ret+0(FP)
+	RET
+
+// Rdrand() (uint64, bool)
+TEXT ·Rdrand(SB), NOSPLIT, $0-9
+	RDRANDQ AX
+	SETCS   ok+8(FP)
+	MOVQ    AX, v+0(FP)
+	RET
+
+// Rdseed() (uint64, bool)
+TEXT ·Rdseed(SB), NOSPLIT, $0-9
+	RDSEEDQ AX
+	SETCS   ok+8(FP)
+	MOVQ    AX, v+0(FP)
+	RET
@@ -4,7 +4,6 @@ import (
 	"syscall"
 	"unsafe"
 
-	"github.com/banditmoscow1337/spos/drivers/qemu"
 	"github.com/banditmoscow1337/spos/drivers/uart"
 	"github.com/banditmoscow1337/spos/gvisor/linux"
 	"github.com/banditmoscow1337/spos/gvisor/linux/errno"
@@ -33,6 +32,7 @@ const (
 	SYS_WAIT_SYSCALL = 501
 	SYS_FIXED_MMAP   = 502
 	SYS_EPOLL_NOTIFY = 503
+	SYS_THREAD_STAT  = 504
 )
 
 const (
@@ -58,9 +58,9 @@ var (
 		syscall.SYS_NANOSLEEP,
 		syscall.SYS_SCHED_YIELD,
 		syscall.SYS_MADVISE,
+		syscall.SYS_EXIT,
 		syscall.SYS_EXIT_GROUP,
-
-		// TODO: real random
+		syscall.SYS_REBOOT,
 		unix.SYS_GETRANDOM,
 
 		// may removed in the future
@@ -68,12 +68,12 @@ var (
 		syscall.SYS_EPOLL_CTL,
 		syscall.SYS_EPOLL_WAIT,
 		syscall.SYS_EPOLL_PWAIT,
-		syscall.SYS_PIPE2,
 
 		SYS_WAIT_IRQ,
 		SYS_WAIT_SYSCALL,
 		SYS_FIXED_MMAP,
 		SYS_EPOLL_NOTIFY,
+		SYS_THREAD_STAT,
 	}
 )
 
@@ -93,9 +93,17 @@ func syscallIntr() {
 	my.systf = *tf
 
 	req := tf.SyscallRequest()
+	req.Tid = my.id
 	doInKernel := !(bootstrapDone && canForward(&req))
 	if doInKernel {
+		no := req.NO()
+		start := nanosecond()
 		doSyscall(&req)
+		dur := nanosecond() - start
+		syscallStat(no, dur)
+		if tracing(no, my.id) {
+			traceRecord(my.id, no, &req, start, dur, false)
+		}
 		return
 	}
 
@@ -129,12 +137,17 @@ func canForward(req *isyscall.Request) bool {
 			return false
 		}
 		// handle pipe write
-		if req.Arg(0) == pipeWriteFd {
+		if isEpollPipeFd(req.Arg(0)) {
 			return false
 		}
 	case syscall.SYS_READ:
 		// handle pipe read
-		if req.Arg(0) == pipeReadFd {
+		if isEpollPipeFd(req.Arg(0)) {
+			return false
+		}
+	case syscall.SYS_PIPE2:
+		// the pipe of the netpoller, the others are made by the vfs
+		if isEpollPipe(my.tf.IP) {
 			return false
 		}
 	}
@@ -174,20 +187,11 @@ func doSyscall(req *isyscall.Request) {
 	no := req.NO()
 	req.SetRet(0)
 
-	// if no != syscall.SYS_SCHED_YIELD {
-	// 	log.PrintStr("call ")
-	// 	if int(no) < len(sysnum) {
-	// 		log.PrintStr(sysnum[no])
-	// 	} else {
-	// 		log.PrintHex(no)
-	// 	}
-	// 	log.PrintStr("\n")
-	// }
 	switch no {
 	case syscall.SYS_ARCH_PRCTL:
 		sysArchPrctl(req)
 	case syscall.SYS_SCHED_GETAFFINITY:
-		req.SetRet(0)
+		sysSchedGetaffinity(req)
 	case syscall.SYS_OPENAT:
 		req.SetRet(isyscall.Errno(errno.ENOSYS))
 	case syscall.SYS_MMAP:
@@ -215,11 +219,15 @@ func doSyscall(req *isyscall.Request) {
 		sysNanosleep(req)
 	case syscall.SYS_SCHED_YIELD:
 		Yield()
+	case syscall.SYS_EXIT:
+		exit()
 	case syscall.SYS_EXIT_GROUP:
 		sysExitGroup(req)
+	case syscall.SYS_REBOOT:
+		sysReboot(req)
 
 	case unix.SYS_GETRANDOM:
-		req.SetRet(req.Arg(1))
+		sysGetrandom(req)
 
 	case syscall.SYS_EPOLL_CREATE1:
 		sysEpollCreate(req)
@@ -238,6 +246,8 @@ func doSyscall(req *isyscall.Request) {
 		sysFixedMmap(req)
 	case SYS_EPOLL_NOTIFY:
 		sysEpollNotify(req)
+	case SYS_THREAD_STAT:
+		sysThreadStat(req)
 
 	default:
 		req.SetRet(isyscall.Errno(errno.ENOSYS))
@@ -259,6 +269,26 @@ func sysArchPrctl(req *isyscall.Request) {
 	}
 }
 
+// sysSchedGetaffinity reports all started cpus,
+// the go runtime uses it as the number of cpus.
+//
+//go:nosplit
+func sysSchedGetaffinity(req *isyscall.Request) {
+	size := req.Arg(1)
+	mask := sys.UnsafeBuffer(req.Arg(2), int(size))
+	for i := range mask {
+		mask[i] = 0
+	}
+	for i := 0; i < ncpu && i/8 < len(mask); i++ {
+		mask[i/8] |= 1 << (i % 8)
+	}
+	n := uintptr((ncpu + 7) / 8)
+	if n > size {
+		n = size
+	}
+	req.SetRet(n)
+}
+
 //go:nosplit
 func sysMmap(req *isyscall.Request) {
 	addr := req.Arg(0)
@@ -273,10 +303,24 @@ func sysMmap(req *isyscall.Request) {
 	}
 
 	// called on sysMap and sysAlloc
-	req.SetRet(mm.Mmap(addr, n))
+	req.SetRet(mm.MmapPerm(addr, n, protPerm(prot)))
 	return
 }
 
+// protPerm converts the PROT_* flags to the page permission
+//
+//go:nosplit
+func protPerm(prot uintptr) uintptr {
+	perm := uintptr(mm.PTE_P | mm.PTE_U | mm.PTE_NX)
+	if prot&syscall.PROT_WRITE != 0 {
+		perm |= mm.PTE_W
+	}
+	if prot&syscall.PROT_EXEC != 0 {
+		perm &^= mm.PTE_NX
+	}
+	return perm
+}
+
 //go:nosplit
 func sysMunmap(req *isyscall.Request) {
 	addr := req.Arg(0)
@@ -317,7 +361,23 @@ func sysWrite(req *isyscall.Request) {
 //go:nosplit
 func sysClockGetTime(req *isyscall.Request) {
 	ts := (*linux.Timespec)(unsafe.Pointer(req.Arg(1)))
-	*ts = clocktime()
+	switch req.Arg(0) {
+	case unix.CLOCK_MONOTONIC, unix.CLOCK_MONOTONIC_RAW, unix.CLOCK_BOOTTIME:
+		n := nanosecond()
+		ts.Sec = n / second
+		ts.Nsec = n % second
+	default:
+		*ts = clocktime()
+	}
+}
+
+// sysGetrandom never blocks, the crng is seeded at boot
+//
+//go:nosplit
+func sysGetrandom(req *isyscall.Request) {
+	n := req.Arg(1)
+	randomRead(sys.UnsafeBuffer(req.Arg(0), int(n)))
+	req.SetRet(n)
 }
 
 //go:nosplit
@@ -327,6 +387,10 @@ func sysClone(req *isyscall.Request) {
 	stack := req.Arg(1)
 	tls := req.Arg(4)
 	tid := clone(pc, stack, flags, tls)
+	if tid < 0 {
+		req.SetRet(isyscall.Errno(errno.EAGAIN))
+		return
+	}
 	req.SetRet(uintptr(tid))
 }
 
@@ -388,9 +452,7 @@ func sysFixedMmap(req *isyscall.Request) {
 
 //go:nosplit
 func sysExitGroup(req *isyscall.Request) {
-	qemu.Exit(int(req.Arg(0)))
-	for {
-	}
+	poweroff(int(req.Arg(0)))
 }
 
 //go:nosplit
@@ -408,10 +470,13 @@ func vdsoInit() {
 	dst := sys.UnsafeBuffer(mm.Mmap(vdsoGettimeofdaySym, 0x100), 0x100)
 	src := sys.UnsafeBuffer(sys.FuncPC(vdsoGettimeofday), 0x100)
 	copy(dst, src)
+	mm.Protect(vdsoGettimeofdaySym, 0x100, mm.PERM_TEXT)
 }
 
+// syscallMSRInit enables the SYSCALL instruction on the current cpu
+//
 //go:nosplit
-func syscallInit() {
+func syscallMSRInit() {
 	// write syscall selector
 	wrmsr(_MSR_STAR, 8<<32)
 	// clear IF when enter syscall
@@ -422,6 +487,11 @@ func syscallInit() {
 	// Enable SYSCALL instruction.
 	efer := rdmsr(_MSR_IA32_EFER)
 	wrmsr(_MSR_IA32_EFER, efer|_EFER_SCE)
+}
+
+//go:nosplit
+func syscallInit() {
+	syscallMSRInit()
 
 	trap.Register(0x80, syscallIntr)
 	epollInit()
@@ -64,6 +64,7 @@ func runSyscallThread() {
 		call := (*isyscall.Request)(unsafe.Pointer(callptr))
 
 		no := call.NO()
+		start := nanosecond()
 		handler := isyscall.GetHandler(no)
 		if handler == nil {
 			log.Errorf("[syscall] unhandled %s(%d)(0x%x, 0x%x, 0x%x, 0x%x, 0x%x, 0x%x)",
@@ -71,6 +72,11 @@ func runSyscallThread() {
 				call.Arg(0), call.Arg(1), call.Arg(2), call.Arg(3),
 				call.Arg(4), call.Arg(5))
 			call.SetErrorNO(syscall.ENOSYS)
+			dur := nanosecond() - start
+			syscallStat(no, dur)
+			if tracing(no, call.Tid) {
+				traceRecord(call.Tid, no, call, start, dur, true)
+			}
 			call.Done()
 			continue
 		}
@@ -88,6 +94,11 @@ func runSyscallThread() {
 				call.Arg(0), call.Arg(1), call.Arg(2), call.Arg(3),
 				call.Arg(4), call.Arg(5), iret,
 			)
+			dur := nanosecond() - start
+			syscallStat(no, dur)
+			if tracing(no, call.Tid) {
+				traceRecord(call.Tid, no, call, start, dur, true)
+			}
 			call.Done()
 		}()
 	}
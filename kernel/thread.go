@@ -1,14 +1,19 @@
 package kernel
 
 import (
+	"syscall"
 	"unsafe"
 
+	"github.com/banditmoscow1337/spos/kernel/isyscall"
 	"github.com/banditmoscow1337/spos/kernel/mm"
 	"github.com/banditmoscow1337/spos/kernel/sys"
 )
 
 const (
-	_NTHREDS = 20
+	// hard limit of the thread table, its virtual address range is reserved at boot
+	_MAX_THREADS = 16 << 10
+	// default limit, can be changed by SetThreadLimit
+	_DEFAULT_THREAD_LIMIT = 1024
 
 	_FLAGS_IF        = 0x200
 	_FLAGS_IOPL_USER = 0x3000
@@ -36,9 +41,15 @@ const (
 )
 
 var (
-	threads    [_NTHREDS]Thread
-	scheduler  *context
-	idleThread threadptr
+	// threads is indexed by thread id, pages are mapped when the table grows
+	threads *[_MAX_THREADS]threadptr
+	// number of slots mapped in threads
+	threadCap int
+	// slots above nthreads are never used
+	nthreads    int
+	threadLimit = _DEFAULT_THREAD_LIMIT
+
+	threadPool mm.Pool
 )
 
 //go:notinheap
@@ -73,41 +84,119 @@ type Thread struct {
 	// sysmon 会调用usleep，进而调用sleepon，如果sleepKey是个指针会触发gcWriteBarrier
 	// 而sysmon没有P，会导致空指针
 	sleepKey uintptr
-	// for sleep timeout
-	timerKey uintptr
+	// for sleep timeout, the link of timerq
+	timerDeadline int64
+	timerNext     threadptr
+	timerQueued   bool
 
 	// store goroutine tls
 	fsBase uintptr
 
 	// 用于保存需要转发的系统调用栈帧
 	systf trapFrame
+
+	// index of the cpu the thread is running or last ran on
+	cpuid int
+	// idle threads are bound to their cpu and never picked by others
+	idle bool
+	// stack is allocated by allocThreadStack instead of the go runtime
+	ownStack bool
 }
 
+//go:nosplit
+func threadTableInit() {
+	mm.PoolInit(&threadPool, unsafe.Sizeof(Thread{}))
+	va := mm.Sbrk(_MAX_THREADS * unsafe.Sizeof(threadptr(0)))
+	threads = (*[_MAX_THREADS]threadptr)(unsafe.Pointer(va))
+}
+
+// growThreadTable maps one more page of the thread table
+//
+//go:nosplit
+func growThreadTable() bool {
+	const slots = int(mm.PGSIZE / unsafe.Sizeof(threadptr(0)))
+	if threadCap+slots > _MAX_THREADS {
+		return false
+	}
+	mm.Mmap(uintptr(unsafe.Pointer(&threads[threadCap])), mm.PGSIZE)
+	threadCap += slots
+	return true
+}
+
+// SetThreadLimit sets the max number of threads, n is capped to the size of the thread table.
+// Existing threads are not affected.
+func SetThreadLimit(n int) {
+	if n > _MAX_THREADS {
+		n = _MAX_THREADS
+	}
+	threadLimit = n
+}
+
+// allocThread returns nil if the thread limit is reached
+//
 //go:nosplit
 func allocThread() *Thread {
-	var t *Thread
-	for i := 0; i < _NTHREDS; i++ {
-		tt := &threads[i]
-		if tt.state == UNUSED {
-			t = tt
-			t.id = i
+	id := -1
+	for i := 0; i < nthreads; i++ {
+		if threads[i] == 0 {
+			id = i
 			break
 		}
 	}
-	if t == nil {
-		throw("no thread slot available")
+	if id == -1 {
+		if nthreads >= threadLimit {
+			return nil
+		}
+		if nthreads == threadCap && !growThreadTable() {
+			return nil
+		}
+		id = nthreads
+		nthreads++
 	}
 
+	t := (*Thread)(unsafe.Pointer(threadPool.Alloc()))
+	t.id = id
 	t.state = INITING
 	t.kstack = allocThreadStack()
 	t.fpstate = mm.Alloc()
 	t.threadTLS[0] = uintptr(unsafe.Pointer(t))
+	threads[id] = (threadptr)(unsafe.Pointer(t))
 	return t
 }
 
+// freeThread releases all memory of an exited thread,
+// the go runtime owns the user stack of cloned threads.
+// Must not be called on the kernel stack of t.
+//
+//go:nosplit
+func freeThread(t *Thread) {
+	// the guard page is released with the stack
+	mm.Munmap(stackGuard(t.kstack), _THREAD_STACK_SIZE+mm.PGSIZE)
+	if t.ownStack {
+		mm.Munmap(stackGuard(t.stack), _THREAD_STACK_SIZE+mm.PGSIZE)
+	}
+	mm.Free(t.fpstate)
+	timerDel(t)
+	threads[t.id] = 0
+	t.state = UNUSED
+	threadPool.Free(uintptr(unsafe.Pointer(t)))
+}
+
+// getThread returns the thread with id, or nil if the slot is unused
+//
+//go:nosplit
+func getThread(id int) *Thread {
+	if id < 0 || id >= nthreads {
+		return nil
+	}
+	return threads[id].ptr()
+}
+
 //go:nosplit
 func allocThreadStack() uintptr {
-	stack := mm.Mmap(0, _THREAD_STACK_SIZE)
+	// the page under the stack is left unmapped to catch overflows
+	guard := mm.Sbrk(_THREAD_STACK_SIZE + mm.PGSIZE)
+	stack := mm.Mmap(guard+mm.PGSIZE, _THREAD_STACK_SIZE)
 	stack += _THREAD_STACK_SIZE - _THREAD_STACK_GUARD_OFFSET
 	return stack
 }
@@ -147,13 +236,14 @@ func switchThreadContext(t *Thread) {
 	setGS(uintptr(unsafe.Pointer(&t.threadTLS)))
 
 	// use current thread esp0 in tss
-	setTssSP0(t.kstack)
+	setTssSP0(&cpus[t.cpuid], t.kstack)
 }
 
 //go:nosplit
 func thread0Init() {
 	t := allocThread()
 	t.stack = allocThreadStack()
+	t.ownStack = true
 
 	sp := t.kstack
 
@@ -198,14 +288,38 @@ func thread0() {
 	panic("main return")
 }
 
-// run when after main init
-func idleInit() {
-	// thread0 clone idle thread
-	stack := mm.SysMmap(0, _THREAD_STACK_SIZE) +
-		_THREAD_STACK_SIZE - _THREAD_STACK_GUARD_OFFSET
+// idleThreadInit creates the idle thread of cpu c,
+// it runs on ring0 which rely on HLT ins
+//
+//go:nosplit
+func idleThreadInit(c *cpu) {
+	t := allocThread()
+	if t == nil {
+		throw("no thread slot for idle thread")
+	}
+	t.stack = allocThreadStack()
+	t.ownStack = true
+	t.idle = true
+	t.cpuid = c.id
+
+	sp := t.kstack
+	sp -= unsafe.Sizeof(trapFrame{})
+	tf := (*trapFrame)(unsafe.Pointer(sp))
+	sys.Fxsave(t.fpstate)
+	tf.SS = _KDATA_IDX << 3
+	tf.SP = t.stack
+	tf.FLAGS = _FLAGS_IF
+	tf.CS = _KCODE_IDX << 3
+	tf.IP = sys.FuncPC(idle)
+	t.tf = tf
 
-	tid := ksysClone(sys.FuncPC(idle), stack, _CLONE_IDLE)
-	idleThread = (threadptr)(unsafe.Pointer(&threads[tid]))
+	sp -= unsafe.Sizeof(context{})
+	ctx := (*context)(unsafe.Pointer(sp))
+	ctx.ip = sys.FuncPC(trapret)
+	t.context = ctx
+
+	t.state = RUNNABLE
+	c.idle = (threadptr)(unsafe.Pointer(t))
 }
 
 //go:nosplit
@@ -219,10 +333,15 @@ func idle() {
 	}
 }
 
+// clone returns -1 if the thread limit is reached
+//
 //go:nosplit
 func clone(pc, usp, flags, tls uintptr) int {
 	my := Mythread()
 	chld := allocThread()
+	if chld == nil {
+		return -1
+	}
 
 	sp := chld.kstack
 	// for trap frame
@@ -242,6 +361,7 @@ func clone(pc, usp, flags, tls uintptr) int {
 	if flags&_CLONE_IDLE != 0 {
 		tf.CS = _KCODE_IDX << 3
 		tf.SS = _KDATA_IDX << 3
+		chld.idle = true
 	}
 
 	// for context
@@ -258,37 +378,43 @@ func clone(pc, usp, flags, tls uintptr) int {
 	return chld.id
 }
 
+// exit terminates the current thread, the scheduler frees it
+// after switching away from its kernel stack.
+//
 //go:nosplit
 func exit() {
 	t := Mythread()
 	t.state = EXIT
-	Yield()
-	// TODO: handle thread exit in scheduler
+	Sched()
+	throw("exited thread scheduled")
 }
 
 //go:nosplit
 func threadInit() {
+	threadTableInit()
 	thread0Init()
+	idleThreadInit(&cpus[0])
 }
 
 //go:nosplit
 func swtch(old **context, _new *context)
 
+// schedule is the scheduler loop of cpu c, the kernel lock must be held
+//
 //go:nosplit
-func schedule() {
+func schedule(c *cpu) {
 	var t *Thread
-	var idx int
 	for {
-		t = pickup(&idx)
-		switchto(t)
+		t = pickup(c)
+		switchto(c, t)
 	}
 }
 
-// pickup selects the next runnable thread
+// pickup selects the next runnable thread for cpu c
 //
 //go:nosplit
-func pickup(pidx *int) *Thread {
-	curr := *pidx
+func pickup(c *cpu) *Thread {
+	curr := c.pidx
 	if traptask != 0 && traptask.ptr().state == RUNNABLE {
 		return traptask.ptr()
 	}
@@ -297,17 +423,18 @@ func pickup(pidx *int) *Thread {
 	}
 
 	var t *Thread
-	for i := 0; i < _NTHREDS; i++ {
-		idx := (curr + i + 1) % _NTHREDS
-		*pidx = idx
-		tt := &threads[idx]
-		if tt.state == RUNNABLE && tt != idleThread.ptr() {
+	n := nthreads
+	for i := 0; i < n; i++ {
+		idx := (curr + i + 1) % n
+		c.pidx = idx
+		tt := threads[idx].ptr()
+		if tt != nil && tt.state == RUNNABLE && !tt.idle {
 			t = tt
 			break
 		}
 	}
 	if t == nil {
-		t = idleThread.ptr()
+		t = c.idle.ptr()
 	}
 	if t == nil {
 		throw("no runnable thread")
@@ -315,43 +442,110 @@ func pickup(pidx *int) *Thread {
 	return t
 }
 
-// switchto switch thread context from scheduler to t
+// switchto switch thread context from scheduler of cpu c to t
 //
 //go:nosplit
-func switchto(t *Thread) {
+func switchto(c *cpu, t *Thread) {
 	begin := nanosecond()
 	// assert that interrupt is enabled
 	// TODO: enable check
 	if t.tf != nil && t.tf.FLAGS&_FLAGS_IF == 0 {
 		throw("bad eflags")
 	}
+	t.cpuid = c.id
 	setMythread(t)
 	t.state = RUNNING
 
-	if t == idleThread.ptr() && t.tf.CS != 8 {
+	if t.idle && t.tf.CS != 8 {
 		throw("bad idle cs")
 
 	}
-	swtch(&scheduler, t.context)
+	timerArm(t)
+	swtch(&c.scheduler, t.context)
 	used := nanosecond() - begin
 	t.counter += used
+	if t.state == EXIT {
+		freeThread(t)
+	}
+}
+
+// ThreadStat appends the running time in nanoseconds of all thread slots to stat,
+// the index is the thread id, unused slots report -1.
+func ThreadStat(stat []int64) []int64 {
+	base := len(stat)
+	for _, t := range Threads() {
+		for len(stat)-base < t.Tid {
+			stat = append(stat, -1)
+		}
+		stat = append(stat, t.Runtime)
+	}
+	return stat
 }
 
-func ThreadStat(stat *[_NTHREDS]int64) {
-	for i := 0; i < _NTHREDS; i++ {
-		stat[i] = threads[i].counter
+// ThreadInfo is the state of a thread slot in use
+type ThreadInfo struct {
+	Tid   int
+	State int
+	// the cpu the thread is running or last ran on
+	CPU  int
+	Idle bool
+	// the running time in nanoseconds
+	Runtime int64
+}
+
+// Threads returns the threads in use ordered by id
+func Threads() []ThreadInfo {
+	buf := make([]ThreadInfo, 64)
+	for {
+		n, _, _ := syscall.Syscall(SYS_THREAD_STAT, uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)), 0)
+		if int(n) <= len(buf) {
+			return buf[:n]
+		}
+		// more threads may be created before the next call
+		buf = make([]ThreadInfo, n+n/4)
+	}
+}
+
+// sysThreadStat copies the threads in use to the ThreadInfo array of arg0 with arg1 entries.
+// The kernel lock keeps the slots from being freed by other cpus, the return value
+// is the number of threads in use, which may be more than arg1.
+//
+//go:nosplit
+func sysThreadStat(req *isyscall.Request) {
+	size := int(req.Arg(1))
+	if size > _MAX_THREADS {
+		size = _MAX_THREADS
+	}
+	buf := (*[_MAX_THREADS]ThreadInfo)(unsafe.Pointer(req.Arg(0)))
+	cnt := 0
+	for i := 0; i < nthreads; i++ {
+		t := threads[i].ptr()
+		if t == nil {
+			continue
+		}
+		if cnt < size {
+			buf[cnt] = ThreadInfo{
+				Tid:     t.id,
+				State:   t.state,
+				CPU:     t.cpuid,
+				Idle:    t.idle,
+				Runtime: t.counter,
+			}
+		}
+		cnt++
 	}
+	req.SetRet(uintptr(cnt))
 }
 
 //go:nosplit
 func Sched() {
 	my := Mythread()
-	swtch(&my.context, scheduler)
+	swtch(&my.context, cpus[my.cpuid].scheduler)
 }
 
 //go:nosplit
 func Yield() {
 	my := Mythread()
 	my.state = RUNNABLE
-	swtch(&my.context, scheduler)
+	swtch(&my.context, cpus[my.cpuid].scheduler)
 }
@@ -1,10 +1,18 @@
 package kernel
 
 import (
+	"math/bits"
+	"unsafe"
+
+	"github.com/banditmoscow1337/spos/drivers/acpi"
+	"github.com/banditmoscow1337/spos/drivers/apic"
+	"github.com/banditmoscow1337/spos/drivers/hpet"
+	"github.com/banditmoscow1337/spos/drivers/irq"
 	"github.com/banditmoscow1337/spos/drivers/pic"
 	"github.com/banditmoscow1337/spos/gvisor/linux"
 	"github.com/banditmoscow1337/spos/kernel/sys"
 	"github.com/banditmoscow1337/spos/kernel/trap"
+	"github.com/banditmoscow1337/spos/log"
 )
 
 const (
@@ -12,6 +20,21 @@ const (
 	_HZ     = 100
 
 	_IRQ_TIMER = pic.IRQ_BASE + pic.LINE_TIMER
+
+	// time slice of a thread when the one-shot timer is used
+	_TIME_SLICE = second / _HZ
+	// the shortest one-shot timer
+	_MIN_TIMER_DELTA = 1000 * ns
+
+	_CPUID_FN_POWER       = 0x80000007
+	_CPUID_EDX_INVARIANT  = 1 << 8
+	_TSC_CALIBRATE_PERIOD = 50 * ms
+)
+
+const (
+	_CLOCK_PIT = iota
+	_CLOCK_HPET
+	_CLOCK_TSC
 )
 
 const (
@@ -21,15 +44,24 @@ const (
 )
 
 var (
-	// the counter of sched clock
+	// the counter of sched clock, only ticks with the pit clocksource
 	counter int64 = 1
 
 	// the unix time of cmos read time
 	baseUnixTime int64
-	// the counter of cmos read time
-	clockBaseCounter int64
+	// the nanosecond of cmos read time
+	clockBaseNano int64
+
+	clocksource = _CLOCK_PIT
+	// nanosecond = (tsc - tscBase) * tscMult >> 32
+	tscBase uint64
+	tscMult uint64
 
-	sleeplock uintptr
+	// all cpus use the local apic timer in one-shot mode, armed on every thread switch
+	oneshot bool
+
+	// timerq is the list of threads waiting for a deadline, ordered by the deadline
+	timerq threadptr
 )
 
 // pitCounter return the current counter of 8259a
@@ -45,8 +77,41 @@ func pitCounter() int32 {
 	return div - ax
 }
 
+// pitDelay busy waits by polling the pit, it works with interrupt disabled
+//
+//go:nosplit
+func pitDelay(n int64) {
+	const div = (_PIT_HZ / _HZ)
+	ticks := n * _PIT_HZ / second
+	last := pitCounter()
+	var elapsed int64
+	for elapsed < ticks {
+		now := pitCounter()
+		d := now - last
+		if d < 0 {
+			d += div
+		}
+		elapsed += int64(d)
+		last = now
+	}
+}
+
+// Uptime returns the time since boot in nanoseconds
+func Uptime() int64 {
+	return nanosecond()
+}
+
+// nanosecond returns the monotonic time since boot
+//
 //go:nosplit
 func nanosecond() int64 {
+	switch clocksource {
+	case _CLOCK_TSC:
+		hi, lo := bits.Mul64(sys.Rdtsc()-tscBase, tscMult)
+		return int64(hi<<32 | lo>>32)
+	case _CLOCK_HPET:
+		return hpet.Nanosecond()
+	}
 	var t int64 = counter * (second / _HZ)
 	elapse := int64(pitCounter()) * (second / _PIT_HZ)
 	t += elapse
@@ -56,37 +121,179 @@ func nanosecond() int64 {
 //go:nosplit
 func clocktime() linux.Timespec {
 	var ts linux.Timespec
-	n := counter - clockBaseCounter
-	ts.Sec = n/_HZ + baseUnixTime
-	ts.Nsec = n % _HZ * (second / _HZ)
-	ts.Nsec += int64(pitCounter()) * (second / _PIT_HZ)
+	n := nanosecond() - clockBaseNano
+	ts.Sec = n/second + baseUnixTime
+	ts.Nsec = n % second
 	return ts
 }
 
+// timerAdd puts t on the timer queue, t becomes runnable at deadline
+//
 //go:nosplit
-func nanosleep(tc *linux.Timespec) {
-	deadline := nanosecond() + int64(tc.Nsec+tc.Sec*second)
+func timerAdd(t *Thread, deadline int64) {
+	t.timerDeadline = deadline
+	pp := &timerq
+	for *pp != 0 && (*pp).ptr().timerDeadline <= deadline {
+		pp = &(*pp).ptr().timerNext
+	}
+	t.timerNext = *pp
+	*pp = (threadptr)(unsafe.Pointer(t))
+	t.timerQueued = true
+}
+
+// timerDel removes t from the timer queue if the deadline not reached
+//
+//go:nosplit
+func timerDel(t *Thread) {
+	if !t.timerQueued {
+		return
+	}
+	pp := &timerq
+	for *pp != 0 {
+		if (*pp).ptr() == t {
+			*pp = t.timerNext
+			break
+		}
+		pp = &(*pp).ptr().timerNext
+	}
+	t.timerNext = 0
+	t.timerQueued = false
+}
+
+// timerExpire wakes up all threads whose deadline has been reached
+//
+//go:nosplit
+func timerExpire() {
+	now := nanosecond()
+	woken := 0
+	for timerq != 0 {
+		t := timerq.ptr()
+		if t.timerDeadline > now {
+			break
+		}
+		timerq = t.timerNext
+		t.timerNext = 0
+		t.timerQueued = false
+		if t.state == SLEEPING {
+			t.state = RUNNABLE
+			woken++
+		}
+	}
+	kickIdle(woken)
+}
+
+// timerArm programs the one-shot timer of the current cpu before running t,
+// the idle thread is only interrupted by the timer queue and kickIdle.
+//
+//go:nosplit
+func timerArm(t *Thread) {
+	if !oneshot {
+		return
+	}
+	const never = int64(^uint64(0) >> 1)
 	now := nanosecond()
-	for now < deadline {
-		sleepon(&sleeplock)
-		now = nanosecond()
+	deadline := never
+	if !t.idle {
+		deadline = now + _TIME_SLICE
+	}
+	if timerq != 0 && timerq.ptr().timerDeadline < deadline {
+		deadline = timerq.ptr().timerDeadline
+	}
+	if deadline == never {
+		apic.StopTimer()
+		return
+	}
+	delta := deadline - now
+	if delta < _MIN_TIMER_DELTA {
+		delta = _MIN_TIMER_DELTA
+	}
+	if delta > second {
+		delta = second
 	}
+	count := uint64(delta) * uint64(lapicTimerTicks) / uint64(_TIME_SLICE)
+	apic.StartTimer(_IRQ_LAPIC_TIMER, uint32(count)+1, false, false)
+}
+
+// sleepUntil sleeps the current thread until deadline
+//
+//go:nosplit
+func sleepUntil(deadline int64) {
+	t := Mythread()
+	timerAdd(t, deadline)
+	for nanosecond() < deadline {
+		t.state = SLEEPING
+		Sched()
+	}
+	timerDel(t)
+}
+
+//go:nosplit
+func nanosleep(tc *linux.Timespec) {
+	sleepUntil(nanosecond() + int64(tc.Nsec+tc.Sec*second))
 }
 
 //go:nosplit
 func timerIntr() {
 	counter++
-	wakeup(&sleeplock, -1)
-	pic.EOI(_IRQ_TIMER)
+	timerExpire()
+	irq.EOI(_IRQ_TIMER)
 	Yield()
 }
 
+// tscInit uses the tsc as clocksource if it's invariant
+//
+//go:nosplit
+func tscInit() bool {
+	maxfn, _, _, _ := cpuid(0x80000000, 0)
+	if maxfn < _CPUID_FN_POWER {
+		return false
+	}
+	_, _, _, edx := cpuid(_CPUID_FN_POWER, 0)
+	if edx&_CPUID_EDX_INVARIANT == 0 {
+		return false
+	}
+	begin := sys.Rdtsc()
+	pitDelay(_TSC_CALIBRATE_PERIOD)
+	freq := (sys.Rdtsc() - begin) * (second / _TSC_CALIBRATE_PERIOD)
+	if freq == 0 {
+		return false
+	}
+	tscMult = (uint64(second) << 32) / freq
+	tscBase = sys.Rdtsc()
+	return true
+}
+
+//go:nosplit
+func clocksourceInit() {
+	if tscInit() {
+		clocksource = _CLOCK_TSC
+		log.PrintStr("[timer] clocksource tsc\n")
+		return
+	}
+	if acpi.HPET.Addr != 0 && hpet.Init(acpi.HPET.Addr) {
+		clocksource = _CLOCK_HPET
+		log.PrintStr("[timer] clocksource hpet\n")
+		return
+	}
+	log.PrintStr("[timer] clocksource pit\n")
+}
+
+// timerInit selects the clocksource and the timer of the scheduler.
+// The pit is always programmed since pitDelay polls it.
+//
 //go:nosplit
 func timerInit() {
 	div := int(_PIT_HZ / _HZ)
 	sys.Outb(0x43, 0x36)
 	sys.Outb(0x40, byte(div&0xff))
 	sys.Outb(0x40, byte((div>>8)&0xff))
+
+	clocksourceInit()
+	if clocksource != _CLOCK_PIT && apic.Enabled() {
+		lapicTimerCalibrate()
+		oneshot = true
+		return
+	}
 	trap.Register(_IRQ_TIMER, timerIntr)
-	pic.EnableIRQ(pic.LINE_TIMER)
+	irq.Enable(pic.LINE_TIMER)
 }
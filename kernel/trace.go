@@ -0,0 +1,184 @@
+package kernel
+
+import (
+	"sync/atomic"
+
+	"github.com/banditmoscow1337/spos/kernel/isyscall"
+)
+
+const (
+	// must be a power of 2
+	_TRACE_RING_SIZE = 1024
+	_TRACE_MAX_NO    = 512
+)
+
+// TraceEvent is a syscall recorded by the tracer
+type TraceEvent struct {
+	// the sequence number of the event, starting from 1
+	Seq  uint64
+	Tid  int
+	No   uintptr
+	Args [6]uintptr
+	Ret  uintptr
+	// the start time and the duration in nanoseconds
+	Start int64
+	Dur   int64
+	// handled by the syscall thread instead of the kernel
+	Forwarded bool
+}
+
+// traceSlot is a seqlock protected event, seq is odd while it's written
+type traceSlot struct {
+	seq uint64
+	ev  TraceEvent
+}
+
+// TraceFilter selects the syscalls recorded by the tracer
+type TraceFilter struct {
+	// the syscalls to record, all if it's empty
+	Nos []uintptr
+	// the syscalls not to record
+	Skip []uintptr
+	// the thread not to record if SkipThread is set, usually the tracer itself
+	SkipThread bool
+	SkipTid    int
+}
+
+var (
+	traceOn      uint32
+	traceHead    uint64
+	traceRing    [_TRACE_RING_SIZE]traceSlot
+	traceMask    [_TRACE_MAX_NO / 64]uint64
+	traceSkipTid int
+)
+
+// StartTrace enables the tracer with the filter f, the events recorded
+// before are kept.
+func StartTrace(f TraceFilter) {
+	atomic.StoreUint32(&traceOn, 0)
+	var mask [len(traceMask)]uint64
+	if len(f.Nos) == 0 {
+		for i := range mask {
+			mask[i] = ^uint64(0)
+		}
+	}
+	for _, no := range f.Nos {
+		if no < _TRACE_MAX_NO {
+			mask[no/64] |= 1 << (no % 64)
+		}
+	}
+	for _, no := range f.Skip {
+		if no < _TRACE_MAX_NO {
+			mask[no/64] &^= 1 << (no % 64)
+		}
+	}
+	for i := range mask {
+		atomic.StoreUint64(&traceMask[i], mask[i])
+	}
+	// thread ids start at 0
+	traceSkipTid = -1
+	if f.SkipThread {
+		traceSkipTid = f.SkipTid
+	}
+	atomic.StoreUint32(&traceOn, 1)
+}
+
+// StopTrace disables the tracer
+func StopTrace() {
+	atomic.StoreUint32(&traceOn, 0)
+}
+
+// TraceHead returns the sequence number of the last recorded event
+func TraceHead() uint64 {
+	return atomic.LoadUint64(&traceHead)
+}
+
+// ReadTrace copies the events after the sequence number since to evs, it returns
+// the number of events copied and the sequence number to read from next time.
+// The events overwritten before being read are lost silently, a gap in Seq tells it.
+func ReadTrace(since uint64, evs []TraceEvent) (int, uint64) {
+	head := atomic.LoadUint64(&traceHead)
+	if head > _TRACE_RING_SIZE && since < head-_TRACE_RING_SIZE {
+		since = head - _TRACE_RING_SIZE
+	}
+	n := 0
+	for ; since < head && n < len(evs); since++ {
+		slot := &traceRing[since%_TRACE_RING_SIZE]
+		want := 2 * (since + 1)
+		if atomic.LoadUint64(&slot.seq) != want {
+			// being written, or overwritten by a newer event
+			continue
+		}
+		ev := slot.ev
+		if atomic.LoadUint64(&slot.seq) != want {
+			continue
+		}
+		evs[n] = ev
+		n++
+	}
+	return n, since
+}
+
+// tracing reports whether the call no of the thread tid is recorded
+//
+//go:nosplit
+func tracing(no uintptr, tid int) bool {
+	if atomic.LoadUint32(&traceOn) == 0 || no >= _TRACE_MAX_NO || tid == traceSkipTid {
+		return false
+	}
+	return atomic.LoadUint64(&traceMask[no/64])&(1<<(no%64)) != 0
+}
+
+// traceRecord appends an event to the ring, it's lock free since
+// the forwarded calls are recorded outside of the kernel lock.
+//
+//go:nosplit
+func traceRecord(tid int, no uintptr, req *isyscall.Request, start, dur int64, forwarded bool) {
+	seq := atomic.AddUint64(&traceHead, 1)
+	slot := &traceRing[(seq-1)%_TRACE_RING_SIZE]
+	atomic.StoreUint64(&slot.seq, 2*seq-1)
+	ev := &slot.ev
+	ev.Seq = seq
+	ev.Tid = tid
+	ev.No = no
+	for i := range ev.Args {
+		ev.Args[i] = req.Arg(i)
+	}
+	ev.Ret = req.Ret()
+	ev.Start = start
+	ev.Dur = dur
+	ev.Forwarded = forwarded
+	atomic.StoreUint64(&slot.seq, 2*seq)
+}
+
+// the names of the syscalls private to the kernel
+var kernelSysnum = map[uintptr]string{
+	SYS_WAIT_IRQ:     "wait_irq",
+	SYS_WAIT_SYSCALL: "wait_syscall",
+	SYS_FIXED_MMAP:   "fixed_mmap",
+	SYS_EPOLL_NOTIFY: "epoll_notify",
+	SYS_THREAD_STAT:  "thread_stat",
+}
+
+// SyscallName returns the name of the syscall no
+func SyscallName(no uintptr) string {
+	if name, ok := kernelSysnum[no]; ok {
+		return name
+	}
+	return syscallName(int(no))
+}
+
+// SyscallNo returns the number of the syscall name
+func SyscallNo(name string) (uintptr, bool) {
+	for no, s := range sysnum {
+		if s == name {
+			return uintptr(no), true
+		}
+	}
+	for no, s := range kernelSysnum {
+		if s == name {
+			return no, true
+		}
+	}
+	return 0, false
+}
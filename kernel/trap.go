@@ -3,7 +3,8 @@ package kernel
 import (
 	"unsafe"
 
-	"github.com/banditmoscow1337/spos/drivers/pic"
+	"github.com/banditmoscow1337/spos/drivers/apic"
+	"github.com/banditmoscow1337/spos/drivers/irq"
 	"github.com/banditmoscow1337/spos/kernel/isyscall"
 	"github.com/banditmoscow1337/spos/kernel/sys"
 	"github.com/banditmoscow1337/spos/kernel/trap"
@@ -80,6 +81,7 @@ func ignoreHandler() {
 //go:nosplit
 func pageFaultHandler() {
 	t := Mythread()
+	checkStackOverflow(t.tf, sys.Cr2())
 	checkKernelPanic(t)
 	changeReturnPC(t.tf, sys.FuncPC(pageFaultPanic))
 }
@@ -103,18 +105,7 @@ func checkKernelPanic(t *Thread) {
 	if tf.CS != _KCODE_IDX<<3 {
 		return
 	}
-	printReg("tid", uintptr(t.id))
-	printReg("no", tf.Trapno)
-	printReg("err", tf.Err)
-	printReg("cr2", sys.Cr2())
-	printReg("ip", tf.IP)
-	printReg("sp", tf.SP)
-	printReg("ax", tf.AX)
-	printReg("bx", tf.BX)
-	printReg("cx", tf.CX)
-	printReg("dx", tf.CX)
-	printReg("cs", tf.CS)
-	throw("trap fault in kernel\n")
+	throwtf(tf, "trap fault in kernel")
 }
 
 //go:nosplit
@@ -141,22 +132,24 @@ func dotrap(tf *trapFrame) {
 		throw("IF should clear")
 	}
 	my := Mythread()
+	// released in trapret
+	kernelLock(my.cpuid)
 	// ugly as it is, avoid writeBarrier
 	// my.tf = tf
 	*(*uintptr)(unsafe.Pointer(&my.tf)) = uintptr(unsafe.Pointer(tf))
+	trapCount(my.cpuid, tf.Trapno)
 
 	handler := trap.Handler(int(tf.Trapno))
 	if handler == nil {
 		faultHandler()
 		return
 	}
-	// timer and syscall interrupts are processed synchronously
-	if tf.Trapno > 32 && tf.Trapno != 0x80 {
-		// pci using level trigger irq, cause dead lock on trap handler
-		// FIXME: hard code network irq line
-		if tf.Trapno == 43 {
-			pic.DisableIRQ(43 - pic.IRQ_BASE)
-		}
+	// timer, local apic and syscall interrupts are processed synchronously
+	if tf.Trapno > _IRQ_TIMER && tf.Trapno < irq.IRQ_BASE+irq.NR_IRQS {
+		// the line stays masked until the trap thread runs the handler,
+		// or a level triggered irq fires again right after the EOI
+		irq.Ack(tf.Trapno)
+		entropyAddIRQ(tf.Trapno)
 		wakeIRQ(tf.Trapno)
 		return
 	}
@@ -165,7 +158,11 @@ func dotrap(tf *trapFrame) {
 
 //go:nosplit
 func trapInit() {
+	trap.Register(_TRAP_DOUBLE_FAULT, doubleFaultHandler)
 	trap.Register(14, pageFaultHandler)
 	trap.Register(39, ignoreHandler)
 	trap.Register(47, ignoreHandler)
+	trap.Register(apic.SpuriousVector, ignoreHandler)
+	trap.Register(apic.TimerVector, lapicTimerIntr)
+	trap.Register(apic.RescheduleVector, rescheduleIntr)
 }
@@ -32,6 +32,9 @@ TEXT alltraps(SB), NOSPLIT, $0
 	JMP   ·trapret(SB)
 
 TEXT ·trapret(SB), NOSPLIT, $0
+	// leaving kernel, taken in dotrap or before schedule
+	CALL ·kernelUnlock(SB)
+
 	// CX store mythread
 	MOVQ 0(GS), CX
 
@@ -6,7 +6,7 @@ import (
 	"syscall"
 	"unsafe"
 
-	"github.com/banditmoscow1337/spos/drivers/pic"
+	"github.com/banditmoscow1337/spos/drivers/irq"
 	"github.com/banditmoscow1337/spos/kernel/trap"
 	"github.com/banditmoscow1337/spos/log"
 )
@@ -38,22 +38,25 @@ func runTrapThread() {
 			if trapset&(1<<i) == 0 {
 				continue
 			}
-			trapno := uintptr(pic.IRQ_BASE + i)
+			trapno := uintptr(irq.IRQ_BASE + i)
 
 			handler := trap.Handler(int(trapno))
 			if handler == nil {
 				fmt.Printf("trap handler for %d not found\n", trapno)
-				pic.EOI(trapno)
 				continue
 			}
+			start := nanosecond()
 			handler()
+			irqStats[i].add(nanosecond() - start)
+			// masked by dotrap
+			irq.Unmask(uint16(i))
 		}
 	}
 }
 
 //go:nosplit
 func wakeIRQ(no uintptr) {
-	irqset |= 1 << (no - pic.IRQ_BASE)
+	irqset |= 1 << (no - irq.IRQ_BASE)
 	wakeup(&irqset, 1)
 	Yield()
 }
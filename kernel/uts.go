@@ -0,0 +1,33 @@
+package kernel
+
+import (
+	"sync/atomic"
+	"syscall"
+)
+
+const (
+	// the size of the fields of utsname without the nul
+	_HOST_NAME_MAX = 64
+
+	_DEFAULT_HOSTNAME = "spos"
+)
+
+var hostname atomic.Value
+
+// Hostname returns the name of the host reported by uname
+func Hostname() string {
+	name, ok := hostname.Load().(string)
+	if !ok {
+		return _DEFAULT_HOSTNAME
+	}
+	return name
+}
+
+// SetHostname returns EINVAL if name is longer than 64 bytes
+func SetHostname(name string) error {
+	if len(name) > _HOST_NAME_MAX {
+		return syscall.EINVAL
+	}
+	hostname.Store(name)
+	return nil
+}
@@ -3,8 +3,8 @@ package log
 import (
 	"bytes"
 	"fmt"
-	"os"
 
+	"github.com/banditmoscow1337/spos/bootcfg"
 	"github.com/banditmoscow1337/spos/console"
 	"github.com/banditmoscow1337/spos/drivers/uart"
 	"github.com/banditmoscow1337/spos/kernel/sys"
@@ -20,8 +20,8 @@ const (
 	LoglvlNone
 )
 
+// the level names of spos_LOGLVL
 const (
-	loglvlEnv      = "spos_LOGLVL"
 	loglvlEnvDebug = "debug"
 	loglvlEnvInfo  = "info"
 	loglvlEnvWarn  = "warn"
@@ -38,8 +38,7 @@ var (
 )
 
 func init() {
-	lvl := os.Getenv("spos_LOGLVL")
-	switch lvl {
+	switch bootcfg.Get().LogLevel {
 	case loglvlEnvDebug:
 		Level = LoglvlDebug
 	case loglvlEnvInfo:
@@ -48,6 +47,8 @@ func init() {
 		Level = LoglvlWarn
 	case loglvlEnvError:
 		Level = LoglvlError
+	case loglvlEnvNone:
+		Level = LoglvlNone
 	default:
 		Level = defaultLoglvl
 	}
@@ -107,3 +108,24 @@ func PrintHex(n uintptr) {
 	}
 	uart.WriteByte(hextab[n&0x0F])
 }
+
+//go:nosplit
+func PrintInt(n int) {
+	if n < 0 {
+		uart.WriteByte('-')
+		n = -n
+	}
+	var buf [20]byte
+	i := len(buf)
+	for {
+		i--
+		buf[i] = byte('0' + n%10)
+		n /= 10
+		if n == 0 {
+			break
+		}
+	}
+	for ; i < len(buf); i++ {
+		uart.WriteByte(buf[i])
+	}
+}
@@ -3,6 +3,7 @@ package spos
 import (
 	"runtime"
 
+	"github.com/banditmoscow1337/spos/bootcfg"
 	"github.com/banditmoscow1337/spos/console"
 	"github.com/banditmoscow1337/spos/drivers/cga/fbcga"
 
@@ -16,24 +17,45 @@ import (
 
 	//"github.com/banditmoscow1337/spos/inet"
 	"github.com/banditmoscow1337/spos/kernel"
+	"github.com/banditmoscow1337/spos/log"
 )
 
+// driverInit calls init unless the driver is disabled by spos_DISABLE
+func driverInit(cfg *bootcfg.Config, name string, init func()) {
+	if cfg.Disabled(name) {
+		return
+	}
+	init()
+}
+
 func kernelInit() {
+	cfg := bootcfg.Get()
 	// trap and syscall threads use two Ps,
 	// and the remainings are for other goroutines
-	runtime.GOMAXPROCS(6)
+	procs := kernel.NumCPU()
+	if procs < 4 {
+		procs = 4
+	}
+	if cfg.MaxProcs != 0 {
+		procs = cfg.MaxProcs
+	}
+	runtime.GOMAXPROCS(procs + 2)
 
 	kernel.Init()
-	uart.Init()
-	kbd.Init()
-	mouse.Init()
+	driverInit(cfg, "uart", uart.Init)
+	driverInit(cfg, "kbd", kbd.Init)
+	driverInit(cfg, "mouse", mouse.Init)
 	console.Init()
 
 	fs.Init()
-	vbe.Init()
-	fbcga.Init()
-	pci.Init()
+	driverInit(cfg, "vbe", vbe.Init)
+	driverInit(cfg, "fbcga", fbcga.Init)
+	driverInit(cfg, "pci", pci.Init)
 	//inet.Init()
+
+	if err := bootcfg.Err(); err != nil {
+		log.Errorf("[bootcfg] %s", err)
+	}
 }
 
 func init() {